@@ -0,0 +1,455 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
+	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
+)
+
+const (
+	usage = `USAGE: %s [OPTIONS...] COMMAND [COMMAND OPTIONS...] [EVENTID...]
+Inspect, prune and verify the local cache.
+
+COMMANDS:
+  list     list cached events, runs and profiles with their ages
+  size     show the total size per event and country
+  prune    delete cached pages by age or event
+  verify   check that cached pages can still be parsed; delete those that can't
+  export   write cached pages to a tar(.gz) bundle
+  import   read cached pages from tar(.gz) bundles (newest wins)
+
+OPTIONS:
+`
+)
+
+type EntryInfo struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	AgeSeconds int64  `json:"age_seconds"`
+	Size       int64  `json:"size"`
+}
+
+type SizeRow struct {
+	Country string `json:"country"`
+	Event   string `json:"event"`
+	Pages   int    `json:"pages"`
+	Size    int64  `json:"size"`
+}
+
+type SizeInfo struct {
+	Events []SizeRow           `json:"events"`
+	Pages  int                 `json:"pages"`
+	Size   int64               `json:"size"`
+	Store  *parkrun.StoreStats `json:"store,omitempty"`
+}
+
+type CommandLineOptions struct {
+	command   string
+	olderThan time.Duration
+	dryRun    bool
+	output    string
+	filter    parkrun.CacheFilter
+	args      []string
+	common    *cli.CommonOptions
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+func parseCommandLine() (CommandLineOptions, error) {
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(flag.Args()) < 1 {
+		return CommandLineOptions{}, cli.Usagef("you have to specify a COMMAND")
+	}
+
+	command := flag.Args()[0]
+	commandFlags := flag.NewFlagSet(command, flag.ExitOnError)
+	olderThan := time.Duration(0)
+	dryRun := false
+	output := ""
+	country := ""
+	since := ""
+	until := ""
+	switch command {
+	case "list", "size":
+	case "prune":
+		commandFlags.DurationVar(&olderThan, "older-than", 0, "only delete pages fetched longer than `DURATION` ago")
+		commandFlags.BoolVar(&dryRun, "dry-run", false, "only print what would be deleted")
+	case "verify":
+		commandFlags.BoolVar(&dryRun, "dry-run", false, "only print what would be deleted")
+	case "export":
+		commandFlags.StringVar(&output, "o", "", "write the bundle to `FILE` (gzip-compressed if it ends with .gz or .tgz)")
+		commandFlags.StringVar(&country, "country", "", "select all events of the specified country")
+		commandFlags.StringVar(&since, "since", "", "select pages fetched on or after `YYYY-MM-DD`")
+		commandFlags.StringVar(&until, "until", "", "select pages fetched before `YYYY-MM-DD`")
+	case "import":
+	default:
+		return CommandLineOptions{}, cli.Usagef("unknown command '%s'", command)
+	}
+	commandFlags.Parse(flag.Args()[1:])
+
+	if command == "prune" && olderThan == 0 && len(commandFlags.Args()) == 0 {
+		return CommandLineOptions{}, cli.Usagef("you have to specify -older-than DURATION and/or one or more EVENTID...")
+	}
+	if command == "export" && output == "" {
+		return CommandLineOptions{}, cli.Usagef("you have to specify -o FILE")
+	}
+	if command == "import" && len(commandFlags.Args()) == 0 {
+		return CommandLineOptions{}, cli.Usagef("you have to specify one or more FILE...")
+	}
+
+	filter := parkrun.CacheFilter{Country: country}
+	if command != "import" {
+		filter.EventIds = commandFlags.Args()
+	}
+	var err error
+	if filter.Since, err = parseDate(since); err != nil {
+		return CommandLineOptions{}, cli.Usagef("invalid -since value: %v", err)
+	}
+	if filter.Until, err = parseDate(until); err != nil {
+		return CommandLineOptions{}, cli.Usagef("invalid -until value: %v", err)
+	}
+
+	return CommandLineOptions{
+		command, olderThan, dryRun, output, filter, commandFlags.Args(), common,
+	}, nil
+}
+
+func fmtAge(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int64(d.Hours()/24))
+	}
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh", int64(d.Hours()))
+	}
+	return fmt.Sprintf("%dm", int64(d.Minutes()))
+}
+
+func fmtSize(size int64) string {
+	if size >= 1024*1024 {
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1024*1024))
+	}
+	if size >= 1024 {
+		return fmt.Sprintf("%.1f KiB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+func filterEntries(entries []*parkrun.CacheEntry, eventIds []string) []*parkrun.CacheEntry {
+	if len(eventIds) == 0 {
+		return entries
+	}
+	selected := make(map[string]bool)
+	for _, eventId := range eventIds {
+		selected[eventId] = true
+	}
+	filtered := make([]*parkrun.CacheEntry, 0)
+	for _, entry := range entries {
+		if selected[entry.EventId] {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+func isGzipFile(fileName string) bool {
+	return strings.HasSuffix(fileName, ".gz") || strings.HasSuffix(fileName, ".tgz")
+}
+
+func exportBundle(client *parkrun.Client, entries []*parkrun.CacheEntry, filter parkrun.CacheFilter, fileName string) error {
+	countries := client.CachedCountries()
+	selected := make([]*parkrun.CacheEntry, 0)
+	for _, entry := range entries {
+		if filter.Matches(entry, countries) {
+			selected = append(selected, entry)
+		}
+	}
+
+	out, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if isGzipFile(fileName) {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	if err := client.ExportCache(w, selected); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("exported %d pages to %s\n", len(selected), fileName)
+	return nil
+}
+
+func importBundle(client *parkrun.Client, fileName string) error {
+	in, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var r io.Reader = in
+	if isGzipFile(fileName) {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	imported, skipped, err := client.ImportCache(r)
+	if err != nil {
+		return fmt.Errorf("while importing '%s': %w", fileName, err)
+	}
+	fmt.Printf("imported %d pages from %s, skipped %d older pages\n", imported, fileName, skipped)
+	return nil
+}
+
+func list(common *cli.CommonOptions, entries []*parkrun.CacheEntry) error {
+	result := make([]EntryInfo, 0, len(entries))
+	t := cli.NewTable("", "Kind", "Name", "Age", "Size")
+	t.AlignRight = []int{3, 4}
+	for _, entry := range entries {
+		result = append(result, EntryInfo{entry.Kind.String(), entry.Name, int64(entry.Age().Seconds()), entry.Size})
+		t.AppendRow(entry.Kind, entry.Name, fmtAge(entry.Age()), fmtSize(entry.Size))
+	}
+	return common.Write(result, t)
+}
+
+func size(common *cli.CommonOptions, client *parkrun.Client, entries []*parkrun.CacheEntry) error {
+	type pageCount struct {
+		files int
+		size  int64
+	}
+	countryNames := client.CachedCountries()
+	events := make(map[string]*pageCount)
+	countries := make(map[string]*pageCount)
+	var total pageCount
+	for _, entry := range entries {
+		country := entry.CountryUrl
+		if name, found := countryNames[country]; found {
+			country = name
+		}
+		event := entry.EventId
+		switch entry.Kind {
+		case parkrun.CacheProfile:
+			country = "-"
+			event = "(profiles)"
+		case parkrun.CacheEvents:
+			country = "-"
+			event = "(other)"
+		}
+
+		key := country + "\t" + event
+		if _, found := events[key]; !found {
+			events[key] = &pageCount{}
+		}
+		if _, found := countries[country]; !found {
+			countries[country] = &pageCount{}
+		}
+		for _, u := range []*pageCount{events[key], countries[country], &total} {
+			u.files += 1
+			u.size += entry.Size
+		}
+	}
+
+	keys := make([]string, 0, len(events))
+	for key := range events {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := SizeInfo{Events: make([]SizeRow, 0, len(keys)), Pages: total.files, Size: total.size}
+	t := cli.NewTable("", "Country", "Event", "Pages", "Size")
+	t.AlignRight = []int{3, 4}
+	lastCountry := ""
+	for _, key := range keys {
+		parts := strings.SplitN(key, "\t", 2)
+		country, event := parts[0], parts[1]
+		if lastCountry != "" && country != lastCountry {
+			c := countries[lastCountry]
+			t.AppendRow(lastCountry, "(total)", c.files, fmtSize(c.size))
+		}
+		lastCountry = country
+		u := events[key]
+		result.Events = append(result.Events, SizeRow{country, event, u.files, u.size})
+		t.AppendRow(country, event, u.files, fmtSize(u.size))
+	}
+	if lastCountry != "" {
+		c := countries[lastCountry]
+		t.AppendRow(lastCountry, "(total)", c.files, fmtSize(c.size))
+	}
+	t.Footer = []interface{}{"", "Total", total.files, fmtSize(total.size)}
+
+	tables := []*cli.Table{t}
+	// the store statistics are not part of the CSV table of the pages
+	if client.Store != nil {
+		stats, err := client.Store.Stats()
+		if err != nil {
+			return err
+		}
+		result.Store = &stats
+		store := cli.NewTable("Store", "Events", "Event Histories", "Runs", "Parkrunners")
+		store.AlignRight = []int{1, 2, 3, 4}
+		store.AppendRow(stats.Events, stats.Histories, stats.Runs, stats.Parkrunners)
+		if common.Format() != cli.FormatCSV {
+			tables = append(tables, store)
+		}
+	}
+	return common.Write(result, tables...)
+}
+
+func remove(entry *parkrun.CacheEntry, dryRun bool, reason string) error {
+	if dryRun {
+		fmt.Printf("would delete %s (%s)\n", entry.Name, reason)
+		return nil
+	}
+	fmt.Printf("deleting %s (%s)\n", entry.Name, reason)
+	return entry.Remove()
+}
+
+func prune(client *parkrun.Client, entries []*parkrun.CacheEntry, eventIds []string, olderThan time.Duration, dryRun bool) error {
+	removed := make([]*parkrun.CacheEntry, 0)
+	for _, entry := range entries {
+		age := entry.Age()
+		if olderThan == 0 || age > olderThan {
+			if err := remove(entry, dryRun, fmt.Sprintf("age %s", fmtAge(age))); err != nil {
+				return err
+			}
+			removed = append(removed, entry)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	// younger ones may still be written by other processes
+	partial, err := client.RemovePartialDownloads(time.Hour)
+	if err != nil {
+		return err
+	}
+	if partial > 0 {
+		fmt.Printf("deleted %d partial downloads\n", partial)
+	}
+
+	if client.Store == nil {
+		return nil
+	}
+	// stored records would otherwise outlive their pages
+	if err := client.Store.RemoveRecords(removed); err != nil {
+		return err
+	}
+	if olderThan == 0 {
+		for _, eventId := range eventIds {
+			if err := client.Store.RemoveEvent(eventId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func verify(client *parkrun.Client, entries []*parkrun.CacheEntry, dryRun bool) error {
+	bad := make([]*parkrun.CacheEntry, 0)
+	failed := 0
+	for _, entry := range entries {
+		err := entry.Verify()
+		if err == nil {
+			continue
+		}
+		// only delete pages that cannot be parsed, not those that cannot be read right now
+		if !errors.Is(err, parkrun.ErrBadPage) {
+			slog.Error("cannot verify page", "name", entry.Name, "err", err)
+			failed += 1
+			continue
+		}
+		if err := remove(entry, dryRun, err.Error()); err != nil {
+			return err
+		}
+		bad = append(bad, entry)
+	}
+	fmt.Printf("verified %d pages, %d bad\n", len(entries)-failed, len(bad))
+
+	if client.Store != nil && !dryRun {
+		if err := client.Store.RemoveRecords(bad); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("cannot verify %d pages", failed)
+	}
+	return nil
+}
+
+func main() {
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	// keep the store consistent with the pages
+	options.common.UseExistingStore()
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	client := options.common.Client()
+
+	entries, err := client.CacheEntries()
+	if err != nil {
+		return err
+	}
+
+	switch options.command {
+	case "list":
+		return list(options.common, filterEntries(entries, options.args))
+	case "size":
+		return size(options.common, client, filterEntries(entries, options.args))
+	case "prune":
+		return prune(client, filterEntries(entries, options.args), options.args, options.olderThan, options.dryRun)
+	case "verify":
+		return verify(client, filterEntries(entries, options.args), options.dryRun)
+	case "export":
+		return exportBundle(client, entries, options.filter, options.output)
+	case "import":
+		for _, fileName := range options.args {
+			if err := importBundle(client, fileName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
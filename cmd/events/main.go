@@ -6,8 +6,7 @@ import (
 	"os"
 	"strings"
 
-	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
-	"github.com/jedib0t/go-pretty/v6/table"
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 )
 
 const (
@@ -18,15 +17,27 @@ OPTIONS:
 `
 )
 
+type EventInfo struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Country string `json:"country"`
+}
+
 func main() {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+	cli.Exit(run())
+}
+
+func run() error {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 	if len(flag.Args()) > 1 {
-		panic("Too many arguments")
+		return cli.Usagef("too many arguments")
 	}
 
 	pattern := ""
@@ -34,23 +45,29 @@ func main() {
 		pattern = strings.ToLower(flag.Arg(0))
 	}
 
+	if err := common.Apply(); err != nil {
+		return err
+	}
+	defer common.Close()
+	ctx := common.Context()
+	client := common.Client()
+
 	if *forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
-	eventList, err := parkrun.AllEvents()
+	eventList, err := client.AllEventsContext(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	t := table.NewWriter()
-	t.SetStyle(table.StyleLight)
-	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Event Id", "Event Name", "Country"})
+	result := make([]EventInfo, 0)
+	t := cli.NewTable("", "Event Id", "Event Name", "Country")
 	for _, event := range eventList {
 		if strings.Contains(strings.ToLower(event.Id), pattern) || strings.Contains(strings.ToLower(event.Name), pattern) {
-			t.AppendRow([]interface{}{event.Id, event.Name, event.Country})
+			result = append(result, EventInfo{event.Id, event.Name, event.Country})
+			t.AppendRow(event.Id, event.Name, event.Country)
 		}
 	}
-	t.Render()
+	return common.Write(result, t)
 }
@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
-	"github.com/jedib0t/go-pretty/v6/table"
-	"github.com/jedib0t/go-pretty/v6/text"
 )
 
 func formatMilestone(number int64) string {
@@ -28,52 +29,75 @@ OPTIONS:
 `
 )
 
+type Candidate struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// junior runs at junior events
+	Runs int64 `json:"runs"`
+	Vols int64 `json:"vols"`
+	// number of the examined runs the parkrunner took part in
+	Active        int  `json:"active"`
+	RunsMilestone bool `json:"runs_milestone"`
+	VolsMilestone bool `json:"vols_milestone"`
+}
+
+type EventMilestones struct {
+	EventId      string      `json:"event_id"`
+	EventName    string      `json:"event_name"`
+	NextRun      int         `json:"next_run"`
+	ExaminedRuns uint64      `json:"examined_runs"`
+	Candidates   []Candidate `json:"candidates"`
+}
+
 type CommandLineOptions struct {
 	forceReload    bool
 	minActiveRatio float64
 	runs           uint64
 	country        string
 	eventIds       []string
+	common         *cli.CommonOptions
 }
 
-func parseCommandLine() CommandLineOptions {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+func parseCommandLine() (CommandLineOptions, error) {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
 	minActiveRatio := flag.Float64("active", 0.3, "minimum active ratio")
 	runs := flag.Uint64("runs", 10, "consider at most the X latest runs of the event")
 	country := flag.String("country", "", "select all events of the specified country")
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 	if *minActiveRatio < 0.0 || *minActiveRatio > 1.0 {
-		panic(fmt.Errorf("invalid -active value: %f; must be between 0 and 1", *minActiveRatio))
+		return CommandLineOptions{}, cli.Usagef("invalid -active value: %f; must be between 0 and 1", *minActiveRatio)
 	}
 	if *country == "" && len(flag.Args()) == 0 {
-		panic("You have to specify either one or more EVENTID... or -country NAME")
+		return CommandLineOptions{}, cli.Usagef("you have to specify either one or more EVENTID... or -country NAME")
 	}
 	if *country != "" && len(flag.Args()) != 0 {
-		panic("You must not specify both one or more EVENTID... and -country NAME")
+		return CommandLineOptions{}, cli.Usagef("you must not specify both one or more EVENTID... and -country NAME")
 	}
 
 	return CommandLineOptions{
-		*forceReload, *minActiveRatio, *runs, *country, flag.Args(),
-	}
+		*forceReload, *minActiveRatio, *runs, *country, flag.Args(), common,
+	}, nil
 }
 
-func getEvents(eventIds []string, country string) []*parkrun.Event {
+func getEvents(ctx context.Context, client *parkrun.Client, eventIds []string, country string) ([]*parkrun.Event, error) {
 	events := make([]*parkrun.Event, 0)
 	for _, eventId := range eventIds {
-		event, err := parkrun.LookupEvent(eventId)
+		event, err := client.LookupEventContext(ctx, eventId)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		events = append(events, event)
 	}
 	if country != "" {
-		eventList, err := parkrun.AllEvents()
+		eventList, err := client.AllEventsContext(ctx)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		lowerCountry := strings.TrimSpace(strings.ToLower(country))
 		for _, event := range eventList {
@@ -82,51 +106,76 @@ func getEvents(eventIds []string, country string) []*parkrun.Event {
 			}
 		}
 	}
-	return events
+	return events, nil
 }
 
 func main() {
-	options := parseCommandLine()
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
 
 	if options.forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
-	events := getEvents(options.eventIds, options.country)
-	for _, event := range events {
-		fmt.Printf("-- Fetching data for %s...\n", event.Name)
-		parkrunners, examinedRuns, err := event.GetActiveParkrunners(options.minActiveRatio, options.runs)
+	events, err := getEvents(ctx, client, options.eventIds, options.country)
+	if err != nil {
+		return err
+	}
+	// the tables are written as soon as an event is done; JSON is a single document and CSV is a single table of all
+	// events, which are only written if all events succeed
+	format := options.common.Format()
+	streaming := format == cli.FormatText || format == cli.FormatMarkdown
+	all := cli.NewTable("", "Event", "Name", "Runs", "Vols", "Active")
+	result := make([]EventMilestones, 0, len(events))
+	for i, event := range events {
+		slog.Info("fetching data", "event", event.Id)
+		parkrunners, examinedRuns, err := event.GetActiveParkrunnersContext(ctx, options.minActiveRatio, options.runs)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		junior := event.IsJuniorParkrun()
-
-		t := table.NewWriter()
-		t.SetStyle(table.StyleLight)
-		t.SetOutputMirror(os.Stdout)
-		t.SetTitle(fmt.Sprintf("Expected Milestones at\n%s\nRun #%d", event.Name, len(event.Runs)+1))
-		t.AppendHeader(table.Row{"Name", "Runs", "Vols", "Active"})
-		if junior {
-			for _, parkrunner := range parkrunners {
-				if parkrun.Milestone(parkrunner.JuniorRuns+1) || parkrun.Milestone(parkrunner.Vols+1) {
-					t.AppendRow([]interface{}{parkrunner.Name, formatMilestone(parkrunner.JuniorRuns), formatMilestone(parkrunner.Vols), fmt.Sprintf("%d/%d", len(parkrunner.Active), examinedRuns)})
-				}
+		milestones := EventMilestones{event.Id, event.Name, len(event.Runs) + 1, examinedRuns, make([]Candidate, 0)}
+		t := cli.NewTable(fmt.Sprintf("Expected Milestones at\n%s\nRun #%d", event.Name, len(event.Runs)+1), "Name", "Runs", "Vols", "Active")
+		t.AlignRight = []int{2, 3, 4}
+		t.WidthMin = []int{30, 4, 4, 5}
+		t.AlignHeaderLeft = true
+		for _, parkrunner := range parkrunners {
+			runs := parkrunner.Runs
+			if junior {
+				runs = parkrunner.JuniorRuns
 			}
-		} else {
-			for _, parkrunner := range parkrunners {
-				if parkrun.Milestone(parkrunner.Runs+1) || parkrun.Milestone(parkrunner.Vols+1) {
-					t.AppendRow([]interface{}{parkrunner.Name, formatMilestone(parkrunner.Runs), formatMilestone(parkrunner.Vols), fmt.Sprintf("%d/%d", len(parkrunner.Active), examinedRuns)})
-				}
+			if parkrun.Milestone(runs+1) || parkrun.Milestone(parkrunner.Vols+1) {
+				milestones.Candidates = append(milestones.Candidates, Candidate{parkrunner.Id, parkrunner.Name, runs, parkrunner.Vols, len(parkrunner.Active), parkrun.Milestone(runs + 1), parkrun.Milestone(parkrunner.Vols + 1)})
+				t.AppendRow(parkrunner.Name, formatMilestone(runs), formatMilestone(parkrunner.Vols), fmt.Sprintf("%d/%d", len(parkrunner.Active), examinedRuns))
+				all.AppendRow(event.Id, parkrunner.Name, formatMilestone(runs), formatMilestone(parkrunner.Vols), fmt.Sprintf("%d/%d", len(parkrunner.Active), examinedRuns))
 			}
 		}
-		t.SetColumnConfigs([]table.ColumnConfig{
-			{Number: 1, WidthMin: 30, AlignHeader: text.AlignLeft},
-			{Number: 2, WidthMin: 4, Align: text.AlignRight, AlignHeader: text.AlignLeft},
-			{Number: 3, WidthMin: 4, Align: text.AlignRight, AlignHeader: text.AlignLeft},
-			{Number: 4, WidthMin: 5, Align: text.AlignRight, AlignHeader: text.AlignLeft},
-		})
-		t.Render()
-		fmt.Println()
+		result = append(result, milestones)
+		if streaming {
+			if i > 0 {
+				fmt.Println()
+			}
+			if err := options.common.Write(nil, t); err != nil {
+				return err
+			}
+		}
+	}
+	if !streaming {
+		return options.common.Write(result, all)
 	}
+	return nil
 }
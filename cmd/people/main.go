@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"sort"
 	"text/template"
 	"time"
 
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
 )
 
@@ -21,12 +25,16 @@ OPTIONS:
 
 type CommandLineOptions struct {
 	forceReload bool
+	snapshot    string
 	eventId     string
 	targetFile  string
+	common      *cli.CommonOptions
 }
 
-func parseCommandLine() CommandLineOptions {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+func parseCommandLine() (CommandLineOptions, error) {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
+	snapshot := flag.String("snapshot", "", "read the event with all runs from the snapshot `FILE` if it exists (and -force is not given), otherwise write it to FILE")
+	common := cli.AddCommonFlags()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
@@ -34,20 +42,12 @@ func parseCommandLine() CommandLineOptions {
 	flag.Parse()
 
 	if len(flag.Args()) != 2 {
-		panic("bad command line")
+		return CommandLineOptions{}, cli.Usagef("you have to specify EVENTID and TARGETFILE")
 	}
 
 	return CommandLineOptions{
-		*forceReload, flag.Args()[0], flag.Args()[1],
-	}
-}
-
-func getEvent(eventId string) *parkrun.Event {
-	event, err := parkrun.LookupEvent(eventId)
-	if err != nil {
-		panic(err)
-	}
-	return event
+		*forceReload, *snapshot, flag.Args()[0], flag.Args()[1], common,
+	}, nil
 }
 
 type Person struct {
@@ -93,45 +93,79 @@ func createPerson(i string, n string, run *parkrun.Run, r uint64, v uint64, pb t
 	return &Person{i, n, run, r, v, 1, pb, rAll, vAll}
 }
 
-func (p *Person) fetchMissingStats() error {
+func (p *Person) fetchMissingStats(ctx context.Context, client *parkrun.Client) error {
 	if p.RunsAll >= 0 || p.VolsAll >= 0 {
 		return nil
 	}
-	url := fmt.Sprintf("https://www.parkrun.org.uk/parkrunner/%s/", p.Id)
-	fmt.Printf("Updating %s %s\n", p.Name, url)
-	fileName := fmt.Sprintf("parkrunner/%s", p.Id)
-	buf, _, err := parkrun.DownloadAndRead(url, fileName)
+	slog.Debug("updating parkrunner", "id", p.Id, "name", p.Name)
+	profile, err := client.ParkrunnerProfileContext(ctx, p.Id)
 	if err != nil {
 		return err
 	}
 
-	_, _, r, j, v, err := parkrun.ExtractData(string(buf))
-	if err != nil {
-		return err
+	p.update(nil, 0, 0, 0, profile.Runs+profile.JuniorRuns, profile.Vols)
+	return nil
+}
+
+// getEvent returns the event with all its runs completed, from the snapshot file if it exists.
+func getEvent(ctx context.Context, client *parkrun.Client, eventId string, snapshot string, forceReload bool) (*parkrun.Event, error) {
+	if snapshot != "" && !forceReload {
+		event, err := client.LoadEvent(snapshot)
+		if err == nil {
+			if event.Id != eventId {
+				return nil, cli.Usagef("the snapshot '%s' is of the event '%s', not '%s'", snapshot, event.Id, eventId)
+			}
+			slog.Debug("using snapshot", "path", snapshot, "event", eventId)
+			return event, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
 	}
 
-	p.update(nil, 0, 0, 0, int64(r+j), int64(v))
-	return nil
+	event, err := client.LookupEventContext(ctx, eventId)
+	if err != nil {
+		return nil, err
+	}
+	if err := event.CompleteRunsContext(ctx); err != nil {
+		return nil, err
+	}
+	if snapshot != "" {
+		if err := parkrun.SaveEvent(snapshot, event); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
 }
 
 func main() {
-	options := parseCommandLine()
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
 
 	if options.forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
-	event := getEvent(options.eventId)
-	if err := event.Complete(); err != nil {
-		panic(err)
+	event, err := getEvent(ctx, client, options.eventId, options.snapshot, options.forceReload)
+	if err != nil {
+		return err
 	}
 
 	personsMap := make(map[string]*Person)
 	for _, run := range event.Runs {
-		if err := run.Complete(); err != nil {
-			panic(err)
-		}
-
 		type rv struct{ r, v *parkrun.Participant }
 		pp := make(map[string]*rv)
 
@@ -195,9 +229,8 @@ func main() {
 
 	persons := make([]*Person, 0, len(personsMap))
 	for _, p := range personsMap {
-		err := p.fetchMissingStats()
-		if err != nil {
-			panic(err)
+		if err := p.fetchMissingStats(ctx, client); err != nil {
+			return err
 		}
 		persons = append(persons, p)
 	}
@@ -214,17 +247,14 @@ func main() {
 
 	t, err := template.ParseFiles("cmd/people/template.html")
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	out, err := os.Create(options.targetFile)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer out.Close()
 
-	err = t.Execute(out, persons)
-	if err != nil {
-		panic(err)
-	}
+	return t.Execute(out, persons)
 }
@@ -4,9 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"time"
 
-	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 )
 
 const (
@@ -20,10 +19,13 @@ OPTIONS:
 type CommandLineOptions struct {
 	forceReload  bool
 	parkrunnerId string
+	common       *cli.CommonOptions
 }
 
-func parseCommandLine() CommandLineOptions {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+func parseCommandLine() (CommandLineOptions, error) {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
@@ -31,27 +33,71 @@ func parseCommandLine() CommandLineOptions {
 	flag.Parse()
 
 	if len(flag.Args()) != 1 {
-		panic("bad command line")
+		return CommandLineOptions{}, cli.Usagef("you have to specify exactly one PARKRUNNER_ID")
+	}
+	if err := common.RejectCSV("a profile"); err != nil {
+		return CommandLineOptions{}, err
 	}
 
 	return CommandLineOptions{
-		*forceReload, flag.Args()[0],
-	}
+		*forceReload, flag.Args()[0], common,
+	}, nil
 }
 
 func main() {
-	options := parseCommandLine()
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
 
 	if options.forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
-	now := time.Now()
+	profile, err := client.ParkrunnerProfileContext(ctx, options.parkrunnerId)
+	if err != nil {
+		return err
+	}
+
+	summary := cli.NewTable("", "Name", "Id", "Runs", "Junior Runs", "Vols", "Age Category", "Club")
+	summary.AppendRow(profile.Name, profile.Id, profile.Runs, profile.JuniorRuns, profile.Vols, profile.AgeCategory, profile.Club)
+
+	events := cli.NewTable("Event Summaries", "Event", "Runs", "Best Gender Position", "Best Position", "Best Time")
+	events.AlignRight = []int{2, 3, 4, 5}
+	for _, e := range profile.Events {
+		events.AppendRow(e.EventName, e.Runs, e.BestGenderPosition, e.BestPosition, e.BestTime)
+	}
 
-	parkrunner := parkrun.Parkrunner{Id: options.parkrunnerId, Runs: -1, Vols: -1, JuniorRuns: -1}
-	if err := parkrunner.FetchMissingStats(now); err != nil {
-		panic(err)
+	results := cli.NewTable("Most Recent Results", "Event", "Date", "Run", "Position", "Time", "Age Grade", "PB")
+	results.AlignRight = []int{3, 4, 5, 6}
+	for _, r := range profile.RecentResults {
+		pb := ""
+		if r.PB {
+			pb = "PB"
+		}
+		results.AppendRow(r.EventName, r.Date.Format("2006-01-02"), r.Run, r.Position, r.Time, fmt.Sprintf("%.2f%%", r.AgeGrade), pb)
 	}
 
-	fmt.Printf("NAME        = %s\nID          = %s\nRUNS        = %d\nJUNIOR RUNS = %d\nVOLS        = %d\n", parkrunner.Name, parkrunner.Id, parkrunner.Runs, parkrunner.JuniorRuns, parkrunner.Vols)
+	roles := cli.NewTable("Volunteer Roles", "Role", "Occasions")
+	roles.AlignRight = []int{2}
+	for _, r := range profile.VolunteerRoles {
+		roles.AppendRow(r.Role, r.Occasions)
+	}
+
+	if options.common.Format() == cli.FormatText {
+		fmt.Printf("NAME         = %s\nID           = %s\nRUNS         = %d\nJUNIOR RUNS  = %d\nVOLS         = %d\nAGE CATEGORY = %s\nCLUB         = %s\n\n", profile.Name, profile.Id, profile.Runs, profile.JuniorRuns, profile.Vols, profile.AgeCategory, profile.Club)
+		return options.common.Write(profile, events, results, roles)
+	}
+	return options.common.Write(profile, summary, events, results, roles)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/flopp/go-parkrunparser"
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
 )
 
@@ -20,49 +22,103 @@ OPTIONS:
 `
 )
 
+type RunStats struct {
+	EventId    string `json:"event_id"`
+	EventName  string `json:"event_name"`
+	Run        uint64 `json:"run"`
+	Date       string `json:"date"`
+	Url        string `json:"url"`
+	Runners    int    `json:"runners"`
+	FirstEvent int    `json:"first_event"`
+	PB         int    `json:"pb"`
+	R1         int    `json:"r1"`
+	R25        int    `json:"r25"`
+	R50        int    `json:"r50"`
+	R100       int    `json:"r100"`
+	R250       int    `json:"r250"`
+	R500       int    `json:"r500"`
+	Volunteers int    `json:"volunteers"`
+	V1         int    `json:"v1"`
+	V25        int    `json:"v25"`
+	V50        int    `json:"v50"`
+	V100       int    `json:"v100"`
+	V250       int    `json:"v250"`
+	V500       int    `json:"v500"`
+	// only with -table
+	RunnerList    []RunnerResult    `json:"runner_list,omitempty"`
+	VolunteerList []VolunteerResult `json:"volunteer_list,omitempty"`
+}
+
+type RunnerResult struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	AgeGroup string `json:"age_group"`
+	Runs     int64  `json:"runs"`
+	// 0 if unknown
+	Seconds int64  `json:"seconds"`
+	Special string `json:"special"`
+}
+
+type VolunteerResult struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Vols int64  `json:"vols"`
+}
+
 type CommandLineOptions struct {
 	forceReload bool
 	fancy       bool
 	table       bool
 	country     string
 	eventIds    []string
+	common      *cli.CommonOptions
 }
 
-func parseCommandLine() CommandLineOptions {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+func parseCommandLine() (CommandLineOptions, error) {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
 	fancy := flag.Bool("fancy", false, "fancy formatting using emoji")
-	table := flag.Bool("table", false, "csv style output")
+	table := flag.Bool("table", false, "list all runners and volunteers")
 	country := flag.String("country", "", "select all events of the specified country")
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 	if *country == "" && len(flag.Args()) == 0 {
-		panic("You have to specify either one or more EVENTID... or -country NAME")
+		return CommandLineOptions{}, cli.Usagef("you have to specify either one or more EVENTID... or -country NAME")
 	}
 	if *country != "" && len(flag.Args()) != 0 {
-		panic("You must not specify both one or more EVENTID... and -country NAME")
+		return CommandLineOptions{}, cli.Usagef("you must not specify both one or more EVENTID... and -country NAME")
+	}
+	if *fancy && (*table || common.Format() != cli.FormatText) {
+		return CommandLineOptions{}, cli.Usagef("you must not specify -fancy together with -table or -format")
+	}
+	if *table {
+		if err := common.RejectCSV("-table"); err != nil {
+			return CommandLineOptions{}, err
+		}
 	}
 
 	return CommandLineOptions{
-		*forceReload, *fancy, *table, *country, flag.Args(),
-	}
+		*forceReload, *fancy, *table, *country, flag.Args(), common,
+	}, nil
 }
 
-func getEvents(eventIds []string, country string) []*parkrun.Event {
+func getEvents(ctx context.Context, client *parkrun.Client, eventIds []string, country string) ([]*parkrun.Event, error) {
 	events := make([]*parkrun.Event, 0)
 	for _, eventId := range eventIds {
-		event, err := parkrun.LookupEvent(eventId)
+		event, err := client.LookupEventContext(ctx, eventId)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		events = append(events, event)
 	}
 	if country != "" {
-		eventList, err := parkrun.AllEvents()
+		eventList, err := client.AllEventsContext(ctx)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		lowerCountry := strings.TrimSpace(strings.ToLower(country))
 		for _, event := range eventList {
@@ -71,7 +127,7 @@ func getEvents(eventIds []string, country string) []*parkrun.Event {
 			}
 		}
 	}
-	return events
+	return events, nil
 }
 
 func pi(n int, icon string, text string) {
@@ -104,194 +160,261 @@ func pi2(n int, icon string, text string) {
 	fmt.Printf("%s%s%s%s: %d\n", indent, icon, sep, text, n)
 }
 
-func printFancy(event *parkrun.Event, run *parkrun.Run, r500, r250, r100, r50, r25, r1, pb, firstEvent, v500, v250, v100, v50, v25, v1 int) {
-	fmt.Printf("%s #️⃣ %d\n", event.Name, int(run.Index))
-	ps(run.Time.Format("02.01.2006"), "📅", "")
+func printFancy(stats *RunStats) {
+	fmt.Printf("%s #️⃣ %d\n", stats.EventName, int(stats.Run))
+	date := stats.Date
+	if t, err := time.Parse("2006-01-02", stats.Date); err == nil {
+		date = t.Format("02.01.2006")
+	}
+	ps(date, "📅", "")
 	ps("", "⛅", "Wetter / weather")
 	ps("", "🎁", "Special")
-	pi(len(run.Runners), "🏃", "Teilnehmer / runners")
-	pi(pb, "⏱️", "Neue Bestzeiten / new PB")
-	pi(firstEvent, "🌍", "Besucher / visitors")
-	pi(r1, "⭐️", "Neue Teilnehmer / first-time runners")
-	pi(len(run.Volunteers), "🦺", "Helfende / volunteers")
-	pi(v1, "⭐️", "Neue Helfende / first-time volunteers")
-	if (r25 + r50 + r100 + r250 + r500 + v25 + v50 + v100 + v250 + v500) > 0 {
-		m := make([]string, 0)
-		if r25 > 0 {
-			m = append(m, fmt.Sprintf("%dxR25", r25))
-		}
-		if r50 > 0 {
-			m = append(m, fmt.Sprintf("%dxR50", r50))
-		}
-		if r100 > 0 {
-			m = append(m, fmt.Sprintf("%dxR100", r100))
-		}
-		if r250 > 0 {
-			m = append(m, fmt.Sprintf("%dxR250", r250))
-		}
-		if r500 > 0 {
-			m = append(m, fmt.Sprintf("%dxR500", r500))
-		}
-		if v25 > 0 {
-			m = append(m, fmt.Sprintf("%dxV25", v25))
-		}
-		if v50 > 0 {
-			m = append(m, fmt.Sprintf("%dxV50", v50))
-		}
-		if v100 > 0 {
-			m = append(m, fmt.Sprintf("%dxV100", v100))
-		}
-		if v250 > 0 {
-			m = append(m, fmt.Sprintf("%dxV250", v250))
-		}
-		if v500 > 0 {
-			m = append(m, fmt.Sprintf("%dxV500", v500))
+	pi(stats.Runners, "🏃", "Teilnehmer / runners")
+	pi(stats.PB, "⏱️", "Neue Bestzeiten / new PB")
+	pi(stats.FirstEvent, "🌍", "Besucher / visitors")
+	pi(stats.R1, "⭐️", "Neue Teilnehmer / first-time runners")
+	pi(stats.Volunteers, "🦺", "Helfende / volunteers")
+	pi(stats.V1, "⭐️", "Neue Helfende / first-time volunteers")
+	m := make([]string, 0)
+	for _, milestone := range []struct {
+		n    int
+		name string
+	}{
+		{stats.R25, "R25"}, {stats.R50, "R50"}, {stats.R100, "R100"}, {stats.R250, "R250"}, {stats.R500, "R500"},
+		{stats.V25, "V25"}, {stats.V50, "V50"}, {stats.V100, "V100"}, {stats.V250, "V250"}, {stats.V500, "V500"},
+	} {
+		if milestone.n > 0 {
+			m = append(m, fmt.Sprintf("%dx%s", milestone.n, milestone.name))
 		}
+	}
+	if len(m) > 0 {
 		ps(strings.Join(m, ", "), "🏆", "Milestones")
 	}
-	fmt.Printf("\nhttps://%s/%s/results/%d/\n", event.CountryUrl, event.Id, run.Index)
+	fmt.Printf("\n%s\n", stats.Url)
 	fmt.Println("#parkrun #running #laufen #mastodonlauftreff")
 }
 
+func printText(stats *RunStats) {
+	pc := func(n int, name string) {
+		if n > 0 {
+			fmt.Printf("- %s: %d\n", name, n)
+		}
+	}
+	fmt.Printf("%s #%d %s\n", stats.EventName, stats.Run, stats.Date)
+	fmt.Printf("Runners: %d\n", stats.Runners)
+	pc(stats.R500, "r500")
+	pc(stats.R250, "r250")
+	pc(stats.R100, "r100")
+	pc(stats.R50, "r50")
+	pc(stats.R25, "r25")
+	pc(stats.R1, "r1")
+	pc(stats.FirstEvent, "first @ event")
+	pc(stats.PB, "pb")
+	fmt.Printf("Volunteers: %d\n", stats.Volunteers)
+	pc(stats.V500, "v500")
+	pc(stats.V250, "v250")
+	pc(stats.V100, "v100")
+	pc(stats.V50, "v50")
+	pc(stats.V25, "v25")
+	pc(stats.V1, "v1")
+	fmt.Printf("Results: %s\n", stats.Url)
+}
+
 func fmtAgeGroup(ageGroup string) string {
 	return ageGroup
 }
 
-func fmtTime(t time.Duration) string {
-	if t == 0 {
+func fmtTime(seconds int64) string {
+	if seconds == 0 {
 		return "n/a"
 	}
-	return fmt.Sprintf("%d", int64(t.Seconds()))
+	return fmt.Sprintf("%d", seconds)
 }
 
-func printTable(event *parkrun.Event, run *parkrun.Run) {
-	fmt.Printf("%s #%d %s\n", event.Name, run.Index, run.Time.Format("2006-01-02"))
-
-	fmt.Println("\nRunners")
-	fmt.Println("Name;Age Group;Total Runs;Finishing Time;Special")
+func collectParticipants(ctx context.Context, client *parkrun.Client, event *parkrun.Event, run *parkrun.Run, stats *RunStats) error {
+	stats.RunnerList = make([]RunnerResult, 0, len(run.Runners))
 	for _, participant := range run.Runners {
-		fmt.Printf("%s;", participant.Name)
+		r := RunnerResult{Id: participant.Id, Name: participant.Name}
 		if participant.Id != "" {
-			fmt.Printf("%s;", fmtAgeGroup(participant.AgeGroup))
-			fmt.Printf("%d;", participant.Runs)
-			fmt.Printf("%s;", fmtTime(participant.Time))
-		} else {
-			fmt.Printf("n/a;n/a;n/a;")
+			r.AgeGroup = fmtAgeGroup(participant.AgeGroup)
+			r.Runs = participant.Runs
+			r.Seconds = int64(participant.Time.Seconds())
 		}
 
 		if participant.Achievement == parkrunparser.AchievementFirst {
 			if participant.Runs == 1 {
-				fmt.Printf("first parkrun")
+				r.Special = "first parkrun"
 			} else {
-				fmt.Printf("first time at %s", event.Name)
+				r.Special = fmt.Sprintf("first time at %s", event.Name)
 			}
 		} else if participant.Achievement == parkrunparser.AchievementPB {
-			fmt.Printf("new personal best at %s", event.Name)
+			r.Special = fmt.Sprintf("new personal best at %s", event.Name)
 		}
+		stats.RunnerList = append(stats.RunnerList, r)
+	}
 
-		fmt.Println("")
+	volunteers := make([]*parkrun.Parkrunner, 0, len(run.Volunteers))
+	for _, participant := range run.Volunteers {
+		volunteers = append(volunteers, &parkrun.Parkrunner{Id: participant.Id, Name: participant.Name, AgeGroup: "??", DataTime: run.Time, Runs: -1, JuniorRuns: -1, Vols: -1, Active: nil})
+	}
+	if err := client.FetchAllMissingStats(ctx, volunteers, run.Time); err != nil {
+		return err
+	}
+	stats.VolunteerList = make([]VolunteerResult, 0, len(volunteers))
+	for _, parkrunner := range volunteers {
+		stats.VolunteerList = append(stats.VolunteerList, VolunteerResult{parkrunner.Id, parkrunner.Name, parkrunner.Vols})
+	}
+	return nil
+}
+
+// printTable prints the runners and volunteers as semicolon separated lines.
+func printTable(stats *RunStats) {
+	fmt.Printf("%s #%d %s\n", stats.EventName, stats.Run, stats.Date)
+
+	fmt.Println("\nRunners")
+	fmt.Println("Name;Age Group;Total Runs;Finishing Time;Special")
+	for _, r := range stats.RunnerList {
+		if r.Id != "" {
+			fmt.Printf("%s;%s;%d;%s;%s\n", r.Name, fmtAgeGroup(r.AgeGroup), r.Runs, fmtTime(r.Seconds), r.Special)
+		} else {
+			fmt.Printf("%s;n/a;n/a;n/a;%s\n", r.Name, r.Special)
+		}
 	}
 
 	fmt.Println("\nVolunteers")
 	fmt.Println("Name;Total Volunteerings")
-	for _, participant := range run.Volunteers {
-		parkrunner := &parkrun.Parkrunner{Id: participant.Id, Name: participant.Name, AgeGroup: "??", DataTime: run.Time, Runs: -1, JuniorRuns: -1, Vols: -1, Active: nil}
-		if err := parkrunner.FetchMissingStats(run.Time); err != nil {
-			panic(err)
+	for _, v := range stats.VolunteerList {
+		fmt.Printf("%s;%d\n", v.Name, v.Vols)
+	}
+}
+
+func participantTables(stats *RunStats) []*cli.Table {
+	title := fmt.Sprintf("%s #%d %s", stats.EventName, stats.Run, stats.Date)
+	runners := cli.NewTable(title+"\nRunners", "Name", "Age Group", "Total Runs", "Finishing Time", "Special")
+	runners.AlignRight = []int{3, 4}
+	for _, r := range stats.RunnerList {
+		if r.Id != "" {
+			runners.AppendRow(r.Name, r.AgeGroup, r.Runs, fmtTime(r.Seconds), r.Special)
+		} else {
+			runners.AppendRow(r.Name, "n/a", "n/a", "n/a", r.Special)
 		}
-		fmt.Printf("%s;%d\n", participant.Name, parkrunner.Vols)
 	}
+	volunteers := cli.NewTable(title+"\nVolunteers", "Name", "Total Volunteerings")
+	volunteers.AlignRight = []int{2}
+	for _, v := range stats.VolunteerList {
+		volunteers.AppendRow(v.Name, v.Vols)
+	}
+	return []*cli.Table{runners, volunteers}
+}
+
+func summaryTable(result []*RunStats) *cli.Table {
+	t := cli.NewTable("", "Event", "Run", "Date", "Runners", "r1", "r25", "r50", "r100", "r250", "r500", "First @ Event", "PB", "Volunteers", "v1", "v25", "v50", "v100", "v250", "v500")
+	for i := 2; i <= 19; i += 1 {
+		if i != 3 {
+			t.AlignRight = append(t.AlignRight, i)
+		}
+	}
+	for _, s := range result {
+		t.AppendRow(s.EventName, s.Run, s.Date, s.Runners, s.R1, s.R25, s.R50, s.R100, s.R250, s.R500, s.FirstEvent, s.PB, s.Volunteers, s.V1, s.V25, s.V50, s.V100, s.V250, s.V500)
+	}
+	return t
 }
 
 func main() {
-	options := parseCommandLine()
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
 
 	if options.forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
-	events := getEvents(options.eventIds, options.country)
+	events, err := getEvents(ctx, client, options.eventIds, options.country)
+	if err != nil {
+		return err
+	}
+	format := options.common.Format()
+	result := make([]*RunStats, 0, len(events))
+	tables := make([]*cli.Table, 0)
 	for _, event := range events {
-		if err := event.Complete(); err != nil {
-			panic(err)
+		if err := event.CompleteContext(ctx); err != nil {
+			return err
 		}
 
-		stats := event.GetStats()
-		if stats == nil {
+		eventStats, err := event.GetStatsContext(ctx)
+		if err != nil {
+			return err
+		}
+		if eventStats == nil {
+			fmt.Fprintf(os.Stderr, "No runs at %s\n", event.Name)
 			continue
 		}
 
 		run := event.Runs[len(event.Runs)-1]
-		firstEvent := len(stats.FirstEvent)
-		pb := len(stats.PB)
-		r1 := len(stats.R1)
-		r25 := len(stats.R25)
-		r50 := len(stats.R50)
-		r100 := len(stats.R100)
-		r250 := len(stats.R250)
-		r500 := len(stats.R500)
-		v1 := len(stats.V1)
-		v25 := len(stats.V25)
-		v50 := len(stats.V50)
-		v100 := len(stats.V100)
-		v250 := len(stats.V250)
-		v500 := len(stats.V500)
+		stats := &RunStats{
+			EventId:    event.Id,
+			EventName:  event.Name,
+			Run:        run.Index,
+			Date:       run.Time.Format("2006-01-02"),
+			Url:        fmt.Sprintf("https://%s/%s/results/%d/", event.CountryUrl, event.Id, run.Index),
+			Runners:    len(run.Runners),
+			FirstEvent: len(eventStats.FirstEvent),
+			PB:         len(eventStats.PB),
+			R1:         len(eventStats.R1),
+			R25:        len(eventStats.R25),
+			R50:        len(eventStats.R50),
+			R100:       len(eventStats.R100),
+			R250:       len(eventStats.R250),
+			R500:       len(eventStats.R500),
+			Volunteers: len(run.Volunteers),
+			V1:         len(eventStats.V1),
+			V25:        len(eventStats.V25),
+			V50:        len(eventStats.V50),
+			V100:       len(eventStats.V100),
+			V250:       len(eventStats.V250),
+			V500:       len(eventStats.V500),
+		}
+		result = append(result, stats)
 
 		if options.table {
-			printTable(event, run)
+			if err := collectParticipants(ctx, client, event, run, stats); err != nil {
+				return err
+			}
+			if format == cli.FormatText {
+				printTable(stats)
+			} else {
+				tables = append(tables, participantTables(stats)...)
+			}
 			continue
 		}
 
-		if options.fancy {
-			printFancy(event, run, r500, r250, r100, r50, r25, r1, pb, firstEvent, v500, v250, v100, v50, v25, v1)
+		if format != cli.FormatText {
 			continue
 		}
-
-		fmt.Printf("%s #%d %s\n", event.Name, run.Index, run.Time.Format("2006-01-02"))
-		fmt.Printf("Runners: %d\n", len(run.Runners))
-		if r500 > 0 {
-			fmt.Printf("- r500: %d\n", r500)
-		}
-		if r250 > 0 {
-			fmt.Printf("- r250: %d\n", r250)
-		}
-		if r100 > 0 {
-			fmt.Printf("- r100: %d\n", r100)
-		}
-		if r50 > 0 {
-			fmt.Printf("- r50: %d\n", r50)
-		}
-		if r25 > 0 {
-			fmt.Printf("- r25: %d\n", r25)
-		}
-		if r1 > 0 {
-			fmt.Printf("- r1: %d\n", r1)
-		}
-		if firstEvent > 0 {
-			fmt.Printf("- first @ event: %d\n", firstEvent)
-		}
-		if pb > 0 {
-			fmt.Printf("- pb: %d\n", pb)
-		}
-		fmt.Printf("Volunteers: %d\n", len(run.Volunteers))
-		if v500 > 0 {
-			fmt.Printf("- v500: %d\n", v500)
-		}
-		if v250 > 0 {
-			fmt.Printf("- v250: %d\n", v250)
-		}
-		if v100 > 0 {
-			fmt.Printf("- v100: %d\n", v100)
-		}
-		if v50 > 0 {
-			fmt.Printf("- v50: %d\n", v50)
-		}
-		if v25 > 0 {
-			fmt.Printf("- v25: %d\n", v25)
-		}
-		if v1 > 0 {
-			fmt.Printf("- v1: %d\n", v1)
+		if options.fancy {
+			printFancy(stats)
+		} else {
+			printText(stats)
 		}
-		fmt.Printf("Results: https://%s/%s/results/%d/\n", event.CountryUrl, event.Id, run.Index)
 	}
+
+	if options.table && format != cli.FormatText {
+		return options.common.Write(result, tables...)
+	}
+	if !options.table && format != cli.FormatText {
+		return options.common.Write(result, summaryTable(result))
+	}
+	return nil
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
+	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
+)
+
+const (
+	usage = `USAGE: %s [OPTIONS...] [EVENTID...]
+Fetch the results of all runs of the specified event(s) or of all
+events of a country (if -country NAME is given) that are newer than
+the last sync into the store, together with the profiles needed by
+parkrun-milestones (with the same -active and -runs) and parkrun-runstats
+(with -all-profiles, also those needed by parkrun-people and
+parkrun-year -guestcountries); with -status, show the sync state of the specified (or all synced)
+events instead.
+
+OPTIONS:
+`
+)
+
+type SyncInfo struct {
+	EventId      string    `json:"event_id"`
+	NewRuns      []uint64  `json:"new_runs"`
+	LastRun      uint64    `json:"last_run"`
+	PreviousSync time.Time `json:"previous_sync"`
+}
+
+type StateInfo struct {
+	EventId  string    `json:"event_id"`
+	LastRun  uint64    `json:"last_run"`
+	LastSync time.Time `json:"last_sync"`
+}
+
+type CommandLineOptions struct {
+	status         bool
+	profiles       bool
+	allProfiles    bool
+	minActiveRatio float64
+	runs           uint64
+	country        string
+	eventIds       []string
+	common         *cli.CommonOptions
+}
+
+func parseCommandLine() (CommandLineOptions, error) {
+	status := flag.Bool("status", false, "show the sync state instead of syncing")
+	profiles := flag.Bool("profiles", true, "also fetch the profiles of the active parkrunners and the latest volunteers")
+	allProfiles := flag.Bool("all-profiles", false, "also fetch the profiles of all runners and volunteers of the synced runs")
+	minActiveRatio := flag.Float64("active", 0.3, "minimum active ratio")
+	runs := flag.Uint64("runs", 10, "consider at most the X latest runs of the event")
+	country := flag.String("country", "", "select all events of the specified country")
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *minActiveRatio < 0.0 || *minActiveRatio > 1.0 {
+		return CommandLineOptions{}, cli.Usagef("invalid -active value: %f; must be between 0 and 1", *minActiveRatio)
+	}
+	if !*status && *country == "" && len(flag.Args()) == 0 {
+		return CommandLineOptions{}, cli.Usagef("you have to specify either one or more EVENTID... or -country NAME")
+	}
+	if *country != "" && len(flag.Args()) != 0 {
+		return CommandLineOptions{}, cli.Usagef("you must not specify both one or more EVENTID... and -country NAME")
+	}
+
+	return CommandLineOptions{
+		*status, *profiles, *allProfiles, *minActiveRatio, *runs, *country, flag.Args(), common,
+	}, nil
+}
+
+func getEvents(ctx context.Context, client *parkrun.Client, eventIds []string, country string) ([]*parkrun.Event, error) {
+	events := make([]*parkrun.Event, 0)
+	for _, eventId := range eventIds {
+		event, err := client.LookupEventContext(ctx, eventId)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if country != "" {
+		eventList, err := client.AllEventsContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		lowerCountry := strings.TrimSpace(strings.ToLower(country))
+		for _, event := range eventList {
+			if strings.ToLower(event.Country) == lowerCountry {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+func fmtTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func status(ctx context.Context, client *parkrun.Client, options CommandLineOptions) error {
+	var states []parkrun.SyncState
+	if options.country == "" && len(options.eventIds) == 0 {
+		var err error
+		if states, err = client.Store.SyncStates(); err != nil {
+			return err
+		}
+	} else {
+		events, err := getEvents(ctx, client, options.eventIds, options.country)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			state, err := client.Store.SyncState(event.Id)
+			if err != nil {
+				return err
+			}
+			states = append(states, state)
+		}
+	}
+
+	result := make([]StateInfo, 0, len(states))
+	t := cli.NewTable("", "Event", "Last Run", "Last Sync")
+	t.AlignRight = []int{2}
+	for _, state := range states {
+		result = append(result, StateInfo{state.EventId, state.LastIndex, state.LastSync})
+		t.AppendRow(state.EventId, state.LastIndex, fmtTime(state.LastSync))
+	}
+	return options.common.Write(result, t)
+}
+
+// participantIds returns the IDs of all runners and volunteers of the given runs of the event.
+func participantIds(event *parkrun.Event, runIndexes []uint64) []string {
+	newRuns := make(map[uint64]bool)
+	for _, index := range runIndexes {
+		newRuns[index] = true
+	}
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for _, run := range event.Runs {
+		if !newRuns[run.Index] {
+			continue
+		}
+		for _, participants := range [][]*parkrun.Participant{run.Runners, run.Volunteers} {
+			for _, p := range participants {
+				if p.Id != "" && !seen[p.Id] {
+					seen[p.Id] = true
+					ids = append(ids, p.Id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func sync(ctx context.Context, client *parkrun.Client, options CommandLineOptions) error {
+	events, err := getEvents(ctx, client, options.eventIds, options.country)
+	if err != nil {
+		return err
+	}
+
+	result := make([]SyncInfo, 0, len(events))
+	t := cli.NewTable("", "Event", "New Runs", "Last Run", "Previous Sync")
+	t.AlignRight = []int{2, 3}
+	total := 0
+	for _, event := range events {
+		previous, err := client.Store.SyncState(event.Id)
+		if err != nil {
+			return err
+		}
+		synced, err := client.SyncEventContext(ctx, event)
+		if err != nil {
+			return err
+		}
+		if options.profiles {
+			// the reports need the totals of parkrunners that are not part of the results pages
+			if _, _, err := event.GetActiveParkrunnersContext(ctx, options.minActiveRatio, options.runs); err != nil {
+				return err
+			}
+			if _, err := event.GetStatsContext(ctx); err != nil {
+				return err
+			}
+		}
+		if options.allProfiles {
+			if err := client.FetchProfiles(ctx, participantIds(event, synced.NewRuns)); err != nil {
+				return err
+			}
+		}
+		total += len(synced.NewRuns)
+		result = append(result, SyncInfo{event.Id, synced.NewRuns, synced.LastIndex, previous.LastSync})
+		t.AppendRow(event.Id, len(synced.NewRuns), synced.LastIndex, fmtTime(previous.LastSync))
+	}
+	t.Footer = []interface{}{"Total", total, "", ""}
+	return options.common.Write(result, t)
+}
+
+func main() {
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	options.common.EnableStore()
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
+
+	if options.status {
+		return status(ctx, client, options)
+	}
+	return sync(ctx, client, options)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
 	"text/template"
 
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
 )
 
@@ -22,42 +24,44 @@ type CommandLineOptions struct {
 	outdir      string
 	country     string
 	eventIds    []string
+	common      *cli.CommonOptions
 }
 
-func parseCommandLine() CommandLineOptions {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+func parseCommandLine() (CommandLineOptions, error) {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
 	outdir := flag.String("outdir", "html", "select output directory")
 	country := flag.String("country", "", "select all events of the specified country")
+	common := cli.AddCommonFlags()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 	if *country == "" && len(flag.Args()) == 0 {
-		panic("You have to specify either one or more EVENTID... or -country NAME")
+		return CommandLineOptions{}, cli.Usagef("you have to specify either one or more EVENTID... or -country NAME")
 	}
 	if *country != "" && len(flag.Args()) != 0 {
-		panic("You must not specify both one or more EVENTID... and -country NAME")
+		return CommandLineOptions{}, cli.Usagef("you must not specify both one or more EVENTID... and -country NAME")
 	}
 
 	return CommandLineOptions{
-		*forceReload, *outdir, *country, flag.Args(),
-	}
+		*forceReload, *outdir, *country, flag.Args(), common,
+	}, nil
 }
 
-func getEvents(eventIds []string, country string) []*parkrun.Event {
+func getEvents(ctx context.Context, client *parkrun.Client, eventIds []string, country string) ([]*parkrun.Event, error) {
 	events := make([]*parkrun.Event, 0)
 	for _, eventId := range eventIds {
-		event, err := parkrun.LookupEvent(eventId)
+		event, err := client.LookupEventContext(ctx, eventId)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		events = append(events, event)
 	}
 	if country != "" {
-		eventList, err := parkrun.AllEvents()
+		eventList, err := client.AllEventsContext(ctx)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		lowerCountry := strings.TrimSpace(strings.ToLower(country))
 		for _, event := range eventList {
@@ -66,7 +70,7 @@ func getEvents(eventIds []string, country string) []*parkrun.Event {
 			}
 		}
 	}
-	return events
+	return events, nil
 }
 
 type Milestone struct {
@@ -76,27 +80,30 @@ type Milestone struct {
 	Active     string
 }
 
-func printEvent(event *parkrun.Event, events []*parkrun.Event, outdir string, t *template.Template) {
+func printEvent(ctx context.Context, event *parkrun.Event, events []*parkrun.Event, outdir string, t *template.Template) error {
 	if err := os.MkdirAll(outdir, 0770); err != nil {
-		panic(err)
+		return err
 	}
 
 	filePath := fmt.Sprintf("%s/%s.html", outdir, event.Id)
 	out, err := os.Create(filePath)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer out.Close()
 
-	stats := event.GetStats()
+	stats, err := event.GetStatsContext(ctx)
+	if err != nil {
+		return err
+	}
 	var run *parkrun.Run
 	if len(event.Runs) > 0 {
 		run = event.Runs[len(event.Runs)-1]
 	}
 
-	parkrunners, examinedRuns, err := event.GetActiveParkrunners(0.3, 10)
+	parkrunners, examinedRuns, err := event.GetActiveParkrunnersContext(ctx, 0.3, 10)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	var milestones []Milestone
 	for _, p := range parkrunners {
@@ -122,30 +129,47 @@ func printEvent(event *parkrun.Event, events []*parkrun.Event, outdir string, t
 		Events:         events,
 	}
 
-	err = t.Execute(out, data)
-	if err != nil {
-		panic(err)
-	}
+	return t.Execute(out, data)
 }
 
 func main() {
-	options := parseCommandLine()
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
 
 	if options.forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
 	t, err := template.ParseFiles("cmd/webgen/event.html")
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	events := getEvents(options.eventIds, options.country)
+	events, err := getEvents(ctx, client, options.eventIds, options.country)
+	if err != nil {
+		return err
+	}
 	for _, event := range events {
-		if err := event.Complete(); err != nil {
-			panic(err)
+		if err := event.CompleteContext(ctx); err != nil {
+			return err
 		}
 
-		printEvent(event, events, options.outdir, t)
+		if err := printEvent(ctx, event, events, options.outdir, t); err != nil {
+			return err
+		}
 	}
+	return nil
 }
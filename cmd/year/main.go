@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"math"
 	"os"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/flopp/go-parkrunparser"
+	cli "github.com/flopp/parkrun-milestones/internal/cli"
 	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
 )
 
@@ -27,44 +29,38 @@ type CommandLineOptions struct {
 	eventId        string
 	year           int
 	guestCountries bool
+	common         *cli.CommonOptions
 }
 
-func parseCommandLine() CommandLineOptions {
-	forceReload := flag.Bool("force", false, "force reload of all data")
+func parseCommandLine() (CommandLineOptions, error) {
+	forceReload := flag.Bool("force", false, "force reload of all data that may have changed")
 	guestCountries := flag.Bool("guestcountries", false, "determine guest countries (may take some time)")
+	common := cli.AddCommonFlags()
+	common.AddFormatFlag()
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	if err := common.RejectCSV("the year statistics"); err != nil {
+		return CommandLineOptions{}, err
+	}
 
 	if len(flag.Args()) == 2 {
 		year, err := strconv.Atoi(flag.Args()[1])
 		if err != nil {
-			flag.Usage()
-			os.Exit(1)
-			return CommandLineOptions{}
+			return CommandLineOptions{}, cli.Usagef("invalid YEAR: %s", flag.Args()[1])
 		}
 		return CommandLineOptions{
-			*forceReload, flag.Args()[0], year, *guestCountries,
-		}
+			*forceReload, flag.Args()[0], year, *guestCountries, common,
+		}, nil
 	} else if len(flag.Args()) == 1 {
 		return CommandLineOptions{
-			*forceReload, flag.Args()[0], 0, *guestCountries,
-		}
+			*forceReload, flag.Args()[0], 0, *guestCountries, common,
+		}, nil
 	} else {
-		flag.Usage()
-		os.Exit(1)
-		return CommandLineOptions{}
-	}
-}
-
-func getEvent(eventId string) *parkrun.Event {
-	event, err := parkrun.LookupEvent(eventId)
-	if err != nil {
-		panic(err)
+		return CommandLineOptions{}, cli.Usagef("you have to specify EVENTID and optionally YEAR")
 	}
-	return event
 }
 
 type CountId struct {
@@ -72,28 +68,82 @@ type CountId struct {
 	Id    string
 }
 
-func printLine(count int, names []string, exclusives, guests int) {
-	sort.Strings(names)
-	fmt.Printf("%3dx #=%d x=%d g=%d %s\n", count, len(names), exclusives, guests, strings.Join(names, ", "))
+type HistogramLine struct {
+	Count int      `json:"count"`
+	Names []string `json:"names"`
+	// only for participants
+	Exclusives int `json:"exclusives,omitempty"`
+	Guests     int `json:"guests,omitempty"`
+}
+
+type PeopleStats struct {
+	Total    int     `json:"total"`
+	Unique   int     `json:"unique"`
+	Max      int     `json:"max"`
+	Avg      float64 `json:"avg"`
+	Min      int     `json:"min"`
+	MaxCount int     `json:"max_count"`
+	MaxNames string  `json:"max_names"`
+}
+
+type RunCount struct {
+	Run          int `json:"run"`
+	Participants int `json:"participants"`
+	Volunteers   int `json:"volunteers"`
+}
+
+type RunGuests struct {
+	Date       string  `json:"date"`
+	Guests     int     `json:"guests"`
+	Percentage float64 `json:"percentage"`
+}
+
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
 }
 
-func printLineVol(count int, names []string) {
-	sort.Strings(names)
-	fmt.Printf("%3dx #=%d %s\n", count, len(names), strings.Join(names, ", "))
+type MinuteCount struct {
+	Minutes int `json:"minutes"`
+	Count   int `json:"count"`
 }
 
-func printHistogram(items []CountId, names map[string]string, id_runs *map[string]int) {
+type YearStats struct {
+	EventId               string          `json:"event_id"`
+	EventName             string          `json:"event_name"`
+	Year                  int             `json:"year"`
+	Runs                  int             `json:"runs"`
+	Participants          PeopleStats     `json:"participants"`
+	Volunteers            PeopleStats     `json:"volunteers"`
+	ParticipantsHistogram []HistogramLine `json:"participants_histogram"`
+	PerRun                []RunCount      `json:"per_run"`
+	SexGroups             []NameCount     `json:"sex_groups"`
+	AgeGroups             []NameCount     `json:"age_groups"`
+	GuestsPerRun          []RunGuests     `json:"guests_per_run"`
+	Guests                int             `json:"guests"`
+	GuestCountries        []NameCount     `json:"guest_countries,omitempty"`
+	MinSeconds            int64           `json:"min_seconds"`
+	MaxSeconds            int64           `json:"max_seconds"`
+	AvgSeconds            int64           `json:"avg_seconds"`
+	RunTimeMinutes        []MinuteCount   `json:"run_time_minutes"`
+	VolunteersHistogram   []HistogramLine `json:"volunteers_histogram"`
+	RunOrVolHistogram     []HistogramLine `json:"run_or_vol_histogram"`
+	FirstNames            []NameCount     `json:"first_names"`
+}
+
+func histogram(items []CountId, names map[string]string, id_runs *map[string]int) []HistogramLine {
+	lines := make([]HistogramLine, 0)
 	last_count := -1
 	ns := make([]string, 0)
 	exclusives := 0
 	guests := 0
+	flush := func() {
+		sort.Strings(ns)
+		lines = append(lines, HistogramLine{last_count, ns, exclusives, guests})
+	}
 	for _, item := range items {
 		if last_count != -1 && last_count != item.Count {
-			if id_runs != nil {
-				printLine(last_count, ns, exclusives, guests)
-			} else {
-				printLineVol(last_count, ns)
-			}
+			flush()
 			ns = make([]string, 0)
 			exclusives = 0
 			guests = 0
@@ -118,24 +168,138 @@ func printHistogram(items []CountId, names map[string]string, id_runs *map[strin
 		last_count = item.Count
 	}
 	if last_count != -1 {
-		if id_runs != nil {
-			printLine(last_count, ns, exclusives, guests)
+		flush()
+	}
+	return lines
+}
+
+// ratio returns a/b, or 0 if b is 0 (e.g. no runs in the year, or a run without runners); JSON has no NaN.
+func ratio(a int, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// sortedCounts returns the counts sorted by name.
+func sortedCounts(counts map[string]int) []NameCount {
+	result := make([]NameCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, NameCount{name, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func countTable(title string, header string, counts []NameCount) *cli.Table {
+	t := cli.NewTable(title, header, "Count")
+	t.AlignRight = []int{2}
+	for _, c := range counts {
+		t.AppendRow(c.Name, c.Count)
+	}
+	return t
+}
+
+func histogramTable(title string, lines []HistogramLine, participants bool) *cli.Table {
+	var t *cli.Table
+	if participants {
+		t = cli.NewTable(title, "Count", "People", "Exclusives", "Guests", "Names")
+		t.AlignRight = []int{1, 2, 3, 4}
+	} else {
+		t = cli.NewTable(title, "Count", "People", "Names")
+		t.AlignRight = []int{1, 2}
+	}
+	for _, line := range lines {
+		if participants {
+			t.AppendRow(line.Count, len(line.Names), line.Exclusives, line.Guests, strings.Join(line.Names, ", "))
 		} else {
-			printLineVol(last_count, ns)
+			t.AppendRow(line.Count, len(line.Names), strings.Join(line.Names, ", "))
 		}
 	}
+	return t
+}
+
+func tables(stats *YearStats) []*cli.Table {
+	summary := cli.NewTable(fmt.Sprintf("Statistics for %s in %d", stats.EventName, stats.Year), "Group", "Total", "Unique", "Max", "Avg", "Min", "Max Count", "Max Names")
+	summary.AlignRight = []int{2, 3, 4, 5, 6, 7}
+	for _, p := range []struct {
+		name  string
+		stats PeopleStats
+	}{{"Participants", stats.Participants}, {"Volunteers", stats.Volunteers}} {
+		summary.AppendRow(p.name, p.stats.Total, p.stats.Unique, p.stats.Max, fmt.Sprintf("%.1f", p.stats.Avg), p.stats.Min, p.stats.MaxCount, p.stats.MaxNames)
+	}
+
+	perRun := cli.NewTable("Participants and Volunteers", "Run", "Participants", "Volunteers")
+	perRun.AlignRight = []int{1, 2, 3}
+	for _, r := range stats.PerRun {
+		perRun.AppendRow(r.Run, r.Participants, r.Volunteers)
+	}
+
+	guests := cli.NewTable("Guests", "Date", "Guests", "Percentage")
+	guests.AlignRight = []int{2, 3}
+	for _, g := range stats.GuestsPerRun {
+		guests.AppendRow(g.Date, g.Guests, fmt.Sprintf("%f", g.Percentage))
+	}
+	guests.Footer = []interface{}{"Total", stats.Guests, ""}
+
+	runTimes := cli.NewTable("Run Times", "Min", "Max", "Avg")
+	runTimes.AppendRow(time.Duration(stats.MinSeconds)*time.Second, time.Duration(stats.MaxSeconds)*time.Second, time.Duration(stats.AvgSeconds)*time.Second)
+
+	runTimeMinutes := cli.NewTable("Run Times (Minutes)", "Minutes", "Count")
+	runTimeMinutes.AlignRight = []int{1, 2}
+	for _, c := range stats.RunTimeMinutes {
+		runTimeMinutes.AppendRow(c.Minutes, c.Count)
+	}
+
+	result := []*cli.Table{
+		summary,
+		histogramTable("Participants Histogram (/g=guest, /x=exclusive)", stats.ParticipantsHistogram, true),
+		perRun,
+		countTable("Sex Groups", "Sex Group", stats.SexGroups),
+		countTable("Age Groups", "Age Group", stats.AgeGroups),
+		guests,
+	}
+	if stats.GuestCountries != nil {
+		result = append(result, countTable("Guest Countries", "Country", stats.GuestCountries))
+	}
+	return append(result,
+		runTimes,
+		runTimeMinutes,
+		histogramTable("Volunteers Histogram", stats.VolunteersHistogram, false),
+		histogramTable("Run Or Vol", stats.RunOrVolHistogram, false),
+		countTable("First Names", "Name", stats.FirstNames),
+	)
 }
 
 func main() {
-	options := parseCommandLine()
+	cli.Exit(run())
+}
+
+func run() error {
+	options, err := parseCommandLine()
+	if err != nil {
+		return err
+	}
+
+	if err := options.common.Apply(); err != nil {
+		return err
+	}
+	defer options.common.Close()
+	ctx := options.common.Context()
+	client := options.common.Client()
 
 	if options.forceReload {
-		parkrun.MaxFileAge = 0
+		client.Freshness = client.Freshness.Forced()
 	}
 
-	event := getEvent(options.eventId)
-	if err := event.Complete(); err != nil {
-		panic(err)
+	event, err := client.LookupEventContext(ctx, options.eventId)
+	if err != nil {
+		return err
+	}
+	if err := event.CompleteRunsContext(ctx); err != nil {
+		return err
 	}
 
 	runs := 0
@@ -169,10 +333,6 @@ func main() {
 	people := make(map[string]*parkrun.Participant, 0)
 	id_runs_at_event := make(map[string]int)
 	for _, run := range event.Runs {
-		if err := run.Complete(); err != nil {
-			panic(err)
-		}
-
 		for _, p := range run.Runners {
 			id_runs_at_event[p.Id] += 1
 		}
@@ -315,84 +475,59 @@ func main() {
 		return count_runsvols[i].Count >= count_runsvols[j].Count
 	})
 
-	/*
-		for id, _ := range people {
-			r, rok := runners[id]
-			v, vok := volunteers[id]
-			if !rok {
-				r = 0
-			}
-			if !vok {
-				v = 0
-			}
-		}
-	*/
-	fmt.Printf("Statistics for %s in %d\n", event.Name, options.year)
-	fmt.Printf("Runs: %d\n", runs)
-	fmt.Printf("Participants:\n")
-	fmt.Printf("    Total: %d\n", sum_runners)
-	fmt.Printf("    Unique: %d\n", len(runners))
-	fmt.Printf("    Max / ø / Min (per event): %d / %.1f / %d\n", max_runners, float64(sum_runners)/float64(runs), min_runners)
-	fmt.Printf("    Max participartions: %d (%s)\n", max_runs, max_runs_names)
-	fmt.Printf("Volunteers:\n")
-	fmt.Printf("    Total: %d\n", sum_volunteers)
-	fmt.Printf("    Unique: %d\n", len(volunteers))
-	fmt.Printf("    Max / ø / Min (per event): %d / %.1f / %d\n", max_volunteers, float64(sum_volunteers)/float64(runs), min_volunteers)
-	fmt.Printf("    Max volunteerings: %d (%s)\n", max_vols, max_vols_names)
-
-	fmt.Println("\nParticipants Histogram (/g=guest, /x=exclusive)")
-	printHistogram(count_runners, names, &id_runs)
-
-	fmt.Println("\nEVENT;PARTICIPANTS;VOLUNTEERS")
-	for i := 0; i < len(stat_participants); i += 1 {
-		fmt.Printf("%d;%d;%d\n", i+1, stat_participants[i], stat_volunteers[i])
+	stats := &YearStats{
+		EventId:               event.Id,
+		EventName:             event.Name,
+		Year:                  options.year,
+		Runs:                  runs,
+		Participants:          PeopleStats{sum_runners, len(runners), max_runners, ratio(sum_runners, runs), min_runners, max_runs, max_runs_names},
+		Volunteers:            PeopleStats{sum_volunteers, len(volunteers), max_volunteers, ratio(sum_volunteers, runs), min_volunteers, max_vols, max_vols_names},
+		ParticipantsHistogram: histogram(count_runners, names, &id_runs),
+		SexGroups:             []NameCount{{"female", sex_female}, {"male", sex_male}, {"unknown", sex_unknown}},
+		AgeGroups:             sortedCounts(ageGroups),
+		VolunteersHistogram:   histogram(count_vols, names, nil),
+		RunOrVolHistogram:     histogram(count_runsvols, names, nil),
 	}
-
-	fmt.Println("\nSEXGROUP;COUNT")
-	fmt.Printf("female;%d\n", sex_female)
-	fmt.Printf("male;%d\n", sex_male)
-	fmt.Printf("unknown;%d\n", sex_unknown)
-
-	fmt.Println("\nAGEGROUP;COUNT")
-	for ageGroup, count := range ageGroups {
-		fmt.Printf("%s;%d\n", ageGroup, count)
+	for i := 0; i < len(stat_participants); i += 1 {
+		stats.PerRun = append(stats.PerRun, RunCount{i + 1, stat_participants[i], stat_volunteers[i]})
 	}
 
-	events, err := parkrun.AllEvents()
+	events, err := client.AllEventsContext(ctx)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	eventCountries := make(map[string]string)
 	for _, event := range events {
 		eventCountries[event.Id] = event.Country
 	}
 	countryCounts := make(map[string]int)
-	guests := 0
 	isGuest := make(map[string]bool)
 	for _, count_id := range count_runners {
 		total_count, ok := id_runs[count_id.Id]
 		if !ok {
-			panic(fmt.Errorf("no total runs for %s", count_id.Id))
+			return fmt.Errorf("no total runs for %s", count_id.Id)
 		}
 		event_count, ok := id_runs_at_event[count_id.Id]
 		if !ok {
-			panic(fmt.Errorf("no event runs for %s", count_id.Id))
+			return fmt.Errorf("no event runs for %s", count_id.Id)
 		}
 
 		if event_count <= total_count/4 {
 			isGuest[count_id.Id] = true
 			if options.guestCountries {
-				country, err := parkrun.GetParkrunnerCountry(count_id.Id, eventCountries)
+				country, err := client.GetParkrunnerCountryContext(ctx, count_id.Id, eventCountries)
 				if err != nil {
-					panic(err)
+					return err
 				}
 				countryCounts[country] += count_id.Count
 			}
-			guests += count_id.Count
+			stats.Guests += count_id.Count
 		}
 	}
+	if options.guestCountries {
+		stats.GuestCountries = sortedCounts(countryCounts)
+	}
 
-	fmt.Println("\nEVENT;GUESTS;PERCENTAGE")
 	for _, run := range event.Runs {
 		if options.year != 0 && run.Time.Year() != options.year {
 			continue
@@ -403,65 +538,39 @@ func main() {
 				guests += 1
 			}
 		}
-		fmt.Printf("%s;%d;%f\n", run.Time.Format("02.01.2006"), guests, float64(guests)/float64(len(run.Runners)))
+		stats.GuestsPerRun = append(stats.GuestsPerRun, RunGuests{run.Time.Format("02.01.2006"), guests, ratio(guests, len(run.Runners))})
 	}
 
-	fmt.Printf("\nGUESTS:\n%d\n", guests)
-	if options.guestCountries {
-		fmt.Println("\nGUEST COUNTRIES:")
-		for country, count := range countryCounts {
-			fmt.Printf("%s;%d\n", country, count)
-		}
+	stats.MinSeconds = int64(min_time.Seconds())
+	stats.MaxSeconds = int64(max_time.Seconds())
+	if count_time > 0 {
+		stats.AvgSeconds = int64(sum_time.Seconds() / float64(count_time))
 	}
-
-	fmt.Println("\nRUN TIMES:")
-	fmt.Printf("min=%v\n", min_time)
-	fmt.Printf("max=%v\n", max_time)
-	fmt.Printf("avg=%v\n", time.Duration(1000000000.0*sum_time.Seconds()/float64(count_time)))
 	times := make([]int, 0, len(time_bins))
-	for t, _ := range time_bins {
+	for t := range time_bins {
 		times = append(times, t)
 	}
 	sort.Ints(times)
-
-	fmt.Println("\nRUN TIME (MINUTES);COUNT")
 	for _, t := range times {
-		count := time_bins[t]
-		fmt.Printf("%d;%d\n", t, count)
+		stats.RunTimeMinutes = append(stats.RunTimeMinutes, MinuteCount{t, time_bins[t]})
 	}
 
-	fmt.Println("\nVolunteers Histogram")
-	printHistogram(count_vols, names, nil)
-
-	fmt.Println("\nRun Or Vol")
-	printHistogram(count_runsvols, names, nil)
-
-	fmt.Println("\nNames Histogram")
 	firstnames_count := make(map[string]int)
 	for _, p := range people {
 		name := p.Name
 		a := strings.Split(name, " ")
 		if len(a) < 2 {
-			//fmt.Println(name)
+			slog.Debug("name without first name", "name", name)
 		} else {
 			firstnames_count[a[0]] += 1
 		}
 	}
-
-	type str_int struct {
-		s string
-		i int
-	}
-
-	firstnames := make([]str_int, 0)
 	for s, i := range firstnames_count {
-		firstnames = append(firstnames, str_int{s, i})
+		stats.FirstNames = append(stats.FirstNames, NameCount{s, i})
 	}
-	sort.Slice(firstnames, func(i, j int) bool {
-		return firstnames[i].i >= firstnames[j].i
+	sort.Slice(stats.FirstNames, func(i, j int) bool {
+		return stats.FirstNames[i].Count >= stats.FirstNames[j].Count
 	})
-	fmt.Println("\nNAME;COUNT")
-	for _, n := range firstnames {
-		fmt.Printf("%s;%d\n", n.s, n.i)
-	}
+
+	return options.common.Write(stats, tables(stats)...)
 }
@@ -1,12 +1,13 @@
 module github.com/flopp/parkrun-milestones
 
-go 1.22
+go 1.23
 
 toolchain go1.26.2
 
 require (
 	github.com/flopp/go-parkrunparser v0.0.1
 	github.com/jedib0t/go-pretty/v6 v6.7.10
+	go.etcd.io/bbolt v1.4.3
 )
 
 require (
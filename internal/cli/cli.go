@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
+)
+
+// CommonOptions holds the command line options shared by all commands.
+type CommonOptions struct {
+	fixtures    string
+	record      string
+	delay       time.Duration
+	burst       int
+	maxRequests int64
+	retries     int
+	compress    bool
+	timeout     time.Duration
+	deadline    time.Duration
+	parallel    int
+	userAgent   string
+	caFile      string
+	insecure    bool
+	progress    bool
+	verbose     bool
+	quiet       bool
+	logFormat   string
+	format      string
+	offline     bool
+	store       bool
+	// use the store if it exists, regardless of -store
+	existingStore bool
+	cacheDir      string
+	cacheProfile  string
+	tempDir       string
+	ctx           context.Context
+	cancel        context.CancelFunc
+	client        *parkrun.Client
+}
+
+func AddCommonFlags() *CommonOptions {
+	options := &CommonOptions{}
+	flag.StringVar(&options.fixtures, "fixtures", "", "serve all downloads from the captured data in `DIR` instead of the network")
+	flag.StringVar(&options.record, "record", "", "capture all downloads to `DIR` (for later use with -fixtures)")
+	flag.DurationVar(&options.delay, "delay", 500*time.Millisecond, "minimum delay between two requests to parkrun")
+	flag.IntVar(&options.burst, "burst", 1, "maximum number of requests sent without -delay")
+	flag.IntVar(&options.retries, "retries", download.DefaultRetryPolicy.Attempts-1, "retry transient network errors `N` times")
+	flag.BoolVar(&options.compress, "compress", false, "store downloaded pages gzip-compressed in the cache (existing pages are compressed when read)")
+	flag.Int64Var(&options.maxRequests, "max-requests", 0, "abort after `N` requests to parkrun (0 = unlimited)")
+	flag.DurationVar(&options.timeout, "timeout", 60*time.Second, "maximum duration of a single request (0 = unlimited)")
+	flag.IntVar(&options.parallel, "parallel", 4, "fetch up to `N` pages concurrently (still subject to -delay)")
+	flag.DurationVar(&options.deadline, "deadline", 0, "abort the whole command after `DURATION` (0 = unlimited)")
+	flag.StringVar(&options.userAgent, "user-agent", download.DefaultUserAgent, "send `STRING` as user agent")
+	flag.StringVar(&options.caFile, "ca-file", "", "trust the CA certificates in the PEM `FILE` in addition to the system's ones")
+	flag.BoolVar(&options.insecure, "insecure", false, "do not verify server certificates (unsafe, for debugging only)")
+	flag.StringVar(&options.cacheDir, "cache-dir", "", "use `DIR` as cache (default: $PARKRUN_CACHE_DIR or the user's cache dir)")
+	flag.StringVar(&options.cacheProfile, "cache-profile", "", "use the cache dir of the profile `NAME` of the config file")
+	flag.BoolVar(&options.offline, "offline", false, "never access the network; serve everything from the cache regardless of its age (also: PARKRUN_OFFLINE=1)")
+	flag.BoolVar(&options.store, "store", false, "keep parsed events, runs and parkrunners in the store of the cache dir")
+	flag.BoolVar(&options.progress, "progress", true, "show the download progress on stderr (not with -v or -q)")
+	flag.BoolVar(&options.verbose, "v", false, "verbose: log all fetches, cache hits and parse results to stderr")
+	flag.BoolVar(&options.quiet, "q", false, "quiet: only log errors")
+	flag.StringVar(&options.logFormat, "log-format", "text", "log format: `text` or json")
+	return options
+}
+
+// Apply creates the parkrun client according to the options; call Close when done.
+func (options *CommonOptions) Apply() error {
+	if options.fixtures != "" && options.record != "" {
+		return Usagef("you must not specify both -fixtures and -record")
+	}
+	if options.delay < 0 {
+		return Usagef("invalid -delay value: %v; must not be negative", options.delay)
+	}
+	if options.burst < 1 {
+		return Usagef("invalid -burst value: %d; must be at least 1", options.burst)
+	}
+
+	if options.retries < 0 {
+		return Usagef("invalid -retries value: %d; must not be negative", options.retries)
+	}
+	if options.timeout < 0 {
+		return Usagef("invalid -timeout value: %v; must not be negative", options.timeout)
+	}
+	if options.deadline < 0 {
+		return Usagef("invalid -deadline value: %v; must not be negative", options.deadline)
+	}
+	if options.parallel < 1 {
+		return Usagef("invalid -parallel value: %d; must be at least 1", options.parallel)
+	}
+	if env := os.Getenv("PARKRUN_OFFLINE"); env != "" && !options.offline {
+		offline, err := strconv.ParseBool(env)
+		if err != nil {
+			return Usagef("invalid PARKRUN_OFFLINE value: '%s'", env)
+		}
+		options.offline = offline
+	}
+	if err := options.validateFormat(); err != nil {
+		return err
+	}
+	if options.offline && (options.fixtures != "" || options.record != "") {
+		return Usagef("you must not specify -offline together with -fixtures or -record")
+	}
+	if (options.cacheDir != "" || options.cacheProfile != "") && (options.fixtures != "" || options.record != "") {
+		return Usagef("you must not specify -cache-dir or -cache-profile together with -fixtures or -record")
+	}
+	if options.cacheDir != "" && options.cacheProfile != "" {
+		return Usagef("you must not specify both -cache-dir and -cache-profile")
+	}
+	if options.verbose && options.quiet {
+		return Usagef("you must not specify both -v and -q")
+	}
+	logger, err := options.newLogger()
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	// Ctrl-C cancels all running requests
+	options.ctx, options.cancel = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if options.deadline > 0 {
+		stop := options.cancel
+		var cancel context.CancelFunc
+		options.ctx, cancel = context.WithTimeoutCause(options.ctx, options.deadline, ErrDeadline)
+		options.cancel = func() {
+			cancel()
+			stop()
+		}
+	}
+
+	if options.insecure {
+		logger.Warn("-insecure: server certificates are not verified")
+	}
+	transport, err := download.NewTransport(download.TransportOptions{CAFile: options.caFile, Insecure: options.insecure})
+	if err != nil {
+		return err
+	}
+
+	client := parkrun.NewClient()
+	client.Parallelism = options.parallel
+	client.Compress = options.compress
+	client.Offline = options.offline
+	if client.CacheDir, err = options.resolveCacheDir(); err != nil {
+		return err
+	}
+	if root, err := client.CachePath(""); err == nil {
+		logger.Debug("using cache", "dir", root)
+	}
+	client.HTTPClient = &http.Client{Transport: transport}
+	client.UserAgent = options.userAgent
+	client.Logger = logger
+	if options.progress && !options.verbose && !options.quiet {
+		client.Observer = newProgressBar(os.Stderr)
+	}
+
+	httpFetcher := client.NewHTTPFetcher()
+	httpFetcher.Timeout = options.timeout
+	var fetcher download.Fetcher = download.Limit(httpFetcher, download.NewRateLimiter(options.delay, options.burst), download.NewBudget(options.maxRequests))
+	retryPolicy := download.DefaultRetryPolicy
+	retryPolicy.Attempts = 1 + options.retries
+	retryPolicy.Logger = logger
+	fetcher = download.Retry(fetcher, retryPolicy)
+	if options.fixtures != "" {
+		fetcher = download.DirFetcher{Dir: options.fixtures}
+	}
+	if options.record != "" {
+		fetcher = download.Record(fetcher, options.record)
+	}
+	client.Fetcher = fetcher
+
+	// use an empty scratch cache, such that everything is taken from (or recorded to) DIR
+	if options.fixtures != "" || options.record != "" {
+		dir, err := os.MkdirTemp("", "parkrun-milestones-")
+		if err != nil {
+			return err
+		}
+		options.tempDir = dir
+		client.CacheDir = dir
+	}
+
+	if options.store || options.existingStore {
+		storePath, err := client.StorePath()
+		if err != nil {
+			return err
+		}
+		_, statErr := os.Stat(storePath)
+		if options.store || statErr == nil {
+			if client.Store, err = parkrun.OpenStore(storePath); err != nil {
+				return err
+			}
+			logger.Debug("using store", "path", storePath)
+		}
+	}
+
+	options.client = client
+	return nil
+}
+
+// resolveCacheDir determines the cache dir from -cache-dir, -cache-profile, PARKRUN_CACHE_DIR and the default profile
+// of the config file (in this order); an empty result means the user's cache dir.
+func (options *CommonOptions) resolveCacheDir() (string, error) {
+	if options.cacheDir != "" {
+		return options.cacheDir, nil
+	}
+	if options.cacheProfile == "" {
+		if dir := os.Getenv("PARKRUN_CACHE_DIR"); dir != "" {
+			return dir, nil
+		}
+	}
+
+	configPath, err := ConfigPath()
+	if err != nil {
+		if options.cacheProfile == "" {
+			return "", nil
+		}
+		return "", err
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return "", err
+	}
+	profile := options.cacheProfile
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	if profile == "" {
+		return "", nil
+	}
+	return config.CacheDir(profile, configPath)
+}
+
+func (options *CommonOptions) newLogger() (*slog.Logger, error) {
+	level := slog.LevelWarn
+	if options.verbose {
+		level = slog.LevelDebug
+	} else if options.quiet {
+		level = slog.LevelError
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	switch options.logFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)), nil
+	}
+	return nil, Usagef("invalid -log-format value: '%s'; must be 'text' or 'json'", options.logFormat)
+}
+
+// Client returns the parkrun client created by Apply.
+func (options *CommonOptions) Client() *parkrun.Client {
+	return options.client
+}
+
+// EnableStore selects the store regardless of -store, for commands that need it; call it before Apply.
+func (options *CommonOptions) EnableStore() {
+	options.store = true
+}
+
+// UseExistingStore selects the store regardless of -store if it exists, for commands that maintain the cache; call
+// it before Apply.
+func (options *CommonOptions) UseExistingStore() {
+	options.existingStore = true
+}
+
+// Context returns the context of the command, which is cancelled on SIGINT, SIGTERM or when -deadline is reached.
+func (options *CommonOptions) Context() context.Context {
+	if options.ctx == nil {
+		return context.Background()
+	}
+	return options.ctx
+}
+
+func (options *CommonOptions) Close() {
+	if options.cancel != nil {
+		options.cancel()
+		options.cancel = nil
+	}
+	if options.client != nil && options.client.Store != nil {
+		if err := options.client.Store.Close(); err != nil {
+			slog.Error("cannot close store", "path", options.client.Store.Path, "err", err)
+		}
+		options.client.Store = nil
+	}
+	if options.tempDir != "" {
+		os.RemoveAll(options.tempDir)
+		options.tempDir = ""
+	}
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config is the optional configuration file shared by all commands, e.g.
+//
+//	{
+//	  "default_profile": "team",
+//	  "profiles": {
+//	    "team": {"cache_dir": "/mnt/nfs/parkrun-cache"},
+//	    "scratch": {"cache_dir": "~/tmp/parkrun-scratch"}
+//	  }
+//	}
+type Config struct {
+	// the cache profile used if neither -cache-dir, -cache-profile nor PARKRUN_CACHE_DIR are given
+	DefaultProfile string                  `json:"default_profile"`
+	Profiles       map[string]CacheProfile `json:"profiles"`
+}
+
+type CacheProfile struct {
+	// "~/" is expanded to the home directory; relative paths are relative to the config file
+	CacheDir string `json:"cache_dir"`
+}
+
+// ConfigPath returns the path of the config file: $PARKRUN_CONFIG, or parkrun-milestones/config.json in the
+// user's config dir.
+func ConfigPath() (string, error) {
+	if p := os.Getenv("PARKRUN_CONFIG"); p != "" {
+		return p, nil
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "parkrun-milestones", "config.json"), nil
+}
+
+// LoadConfig reads the config file at configPath; a missing file results in an empty config.
+func LoadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+	buf, err := os.ReadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, config); err != nil {
+		return nil, fmt.Errorf("while reading config file '%s': %w", configPath, err)
+	}
+	return config, nil
+}
+
+// CacheDir returns the absolute cache dir of the named profile.
+func (config *Config) CacheDir(profile string, configPath string) (string, error) {
+	p, found := config.Profiles[profile]
+	if !found {
+		return "", Usagef("unknown cache profile '%s' (see '%s')", profile, configPath)
+	}
+	if p.CacheDir == "" {
+		return "", fmt.Errorf("cache profile '%s' has no cache_dir (see '%s')", profile, configPath)
+	}
+
+	dir := p.CacheDir
+	if strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, dir[2:])
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(configPath), dir)
+	}
+	return dir, nil
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
+)
+
+// Exit codes of all commands; see README.md.
+const (
+	ExitOK = 0
+	// any error not covered below
+	ExitFailure = 1
+	// bad command line
+	ExitUsage = 2
+	// unknown event, parkrunner or run, or a page that is not cached in offline mode
+	ExitNotFound = 3
+	// network errors, including timeouts and non-OK HTTP statuses
+	ExitNetwork = 4
+	// parkrun served a block or challenge page
+	ExitBlocked = 5
+	// a page could not be parsed
+	ExitParse = 6
+	// aborted by -deadline or -max-requests
+	ExitLimit = 7
+	// aborted by Ctrl-C or SIGTERM
+	ExitInterrupted = 130
+)
+
+// UsageError reports a bad command line.
+type UsageError struct {
+	Message string
+}
+
+func (err *UsageError) Error() string {
+	return err.Message
+}
+
+func Usagef(format string, a ...any) error {
+	return &UsageError{fmt.Sprintf(format, a...)}
+}
+
+// ErrDeadline is the cause of the cancellation of the command's context when -deadline is reached; see
+// CommonOptions.Apply.
+var ErrDeadline = errors.New("deadline reached")
+
+// ExitCode maps err to one of the Exit* codes.
+func ExitCode(err error) int {
+	var usageErr *UsageError
+	var parseErr *parkrun.ParseError
+	var fetchErr *download.FetchError
+	var statusErr *download.StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.Is(err, ErrDeadline):
+		// the requests that were running then fail with all kinds of network or cancellation errors
+		return ExitLimit
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, download.ErrBudgetExceeded):
+		return ExitLimit
+	case errors.Is(err, parkrun.ErrBlocked):
+		return ExitBlocked
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		// e.g. an unknown parkrunner
+		return ExitNotFound
+	case errors.As(err, &fetchErr), errors.As(err, &statusErr), errors.As(err, &netErr):
+		// including the timeouts of single requests
+		return ExitNetwork
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitLimit
+	case errors.As(err, &parseErr):
+		return ExitParse
+	case errors.Is(err, parkrun.ErrEventNotFound), errors.Is(err, parkrun.ErrBadRunIndex), errors.Is(err, download.ErrNotFound), errors.Is(err, parkrun.ErrNotCached):
+		return ExitNotFound
+	}
+	return ExitFailure
+}
+
+// Exit terminates the command with the exit code of err after printing err to stderr; it returns if err is nil.
+func Exit(err error) {
+	if err == nil {
+		return
+	}
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+	var usageErr *UsageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintf(os.Stderr, "Run '%s -help' for usage.\n", name)
+	}
+	os.Exit(ExitCode(err))
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+)
+
+func TestExitCodeDeadline(t *testing.T) {
+	fetchErr := &download.FetchError{Url: "https://www.parkrun.org.uk/", Err: context.DeadlineExceeded}
+	if code := ExitCode(fetchErr); code != ExitNetwork {
+		t.Errorf("timeout of a single request: got %d, expected %d", code, ExitNetwork)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Nanosecond, ErrDeadline)
+	defer cancel()
+	<-ctx.Done()
+	if code := ExitCode(fmt.Errorf("%w: while fetching: %w", context.Cause(ctx), fetchErr)); code != ExitLimit {
+		t.Errorf("-deadline reached: got %d, expected %d", code, ExitLimit)
+	}
+}
+
+func TestExitCodeStatus(t *testing.T) {
+	for status, expected := range map[int]int{http.StatusNotFound: ExitNotFound, http.StatusInternalServerError: ExitNetwork} {
+		err := &download.FetchError{Url: "https://www.parkrun.org.uk/parkrunner/1/", Attempts: 1, Err: &download.StatusError{Url: "https://www.parkrun.org.uk/parkrunner/1/", StatusCode: status}}
+		if code := ExitCode(fmt.Errorf("while fetching: %w", err)); code != expected {
+			t.Errorf("HTTP status %d: got %d, expected %d", status, code, expected)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// Table is a format independent table of a command's output.
+type Table struct {
+	Title  string
+	Header []string
+	Rows   [][]interface{}
+	// totals, not written as CSV
+	Footer []interface{}
+	// numbers (starting at 1) of the right-aligned columns
+	AlignRight []int
+	// minimum widths of the columns, in column order; not used for CSV
+	WidthMin []int
+	// align all headers left, even those of right-aligned columns
+	AlignHeaderLeft bool
+}
+
+func NewTable(title string, header ...string) *Table {
+	return &Table{Title: title, Header: header}
+}
+
+func (t *Table) AppendRow(row ...interface{}) {
+	t.Rows = append(t.Rows, row)
+}
+
+// AddFormatFlag adds the -format option; commands without it always use the text format.
+func (options *CommonOptions) AddFormatFlag() {
+	flag.StringVar(&options.format, "format", FormatText, "output format: `text`, json, csv or markdown")
+}
+
+// Format returns the selected output format.
+func (options *CommonOptions) Format() string {
+	if options.format == "" {
+		return FormatText
+	}
+	return options.format
+}
+
+// RejectCSV fails if -format csv is selected for an output of several tables, which cannot be written as a single
+// CSV table; what describes the output.
+func (options *CommonOptions) RejectCSV(what string) error {
+	if options.Format() == FormatCSV {
+		return Usagef("-format csv is not supported for %s, which consists of several tables; use json instead", what)
+	}
+	return nil
+}
+
+func (options *CommonOptions) validateFormat() error {
+	switch options.Format() {
+	case FormatText, FormatJSON, FormatCSV, FormatMarkdown:
+		return nil
+	}
+	return Usagef("invalid -format value: '%s'; must be 'text', 'json', 'csv' or 'markdown'", options.format)
+}
+
+// Write writes result to stdout as JSON, or the tables in the other formats; CSV output must consist of exactly one
+// table (see RejectCSV).
+func (options *CommonOptions) Write(result interface{}, tables ...*Table) error {
+	return WriteFormat(os.Stdout, options.Format(), result, tables...)
+}
+
+func WriteFormat(w io.Writer, format string, result interface{}, tables ...*Table) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case FormatCSV:
+		if len(tables) != 1 {
+			return fmt.Errorf("CSV output needs exactly one table, got %d", len(tables))
+		}
+		out := csv.NewWriter(w)
+		if err := out.Write(tables[0].Header); err != nil {
+			return err
+		}
+		for _, row := range tables[0].Rows {
+			if err := out.Write(cells(row)); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	case FormatMarkdown:
+		for i, t := range tables {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			if t.Title != "" {
+				fmt.Fprintf(w, "### %s\n\n", strings.ReplaceAll(t.Title, "\n", " "))
+			}
+			tw := t.writer(w)
+			if t.Footer != nil {
+				// markdown has no footers
+				tw.AppendRow(t.Footer)
+			}
+			tw.RenderMarkdown()
+		}
+		return nil
+	}
+
+	for i, t := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := t.writer(w)
+		tw.SetStyle(table.StyleLight)
+		if t.Title != "" {
+			tw.SetTitle(t.Title)
+		}
+		if t.Footer != nil {
+			tw.AppendFooter(t.Footer)
+		}
+		tw.Render()
+	}
+	return nil
+}
+
+func (t *Table) writer(w io.Writer) table.Writer {
+	tw := table.NewWriter()
+	tw.SetOutputMirror(w)
+	header := make(table.Row, 0, len(t.Header))
+	for _, h := range t.Header {
+		header = append(header, h)
+	}
+	tw.AppendHeader(header)
+	for _, row := range t.Rows {
+		tw.AppendRow(row)
+	}
+	configs := make([]table.ColumnConfig, len(t.Header))
+	for i := range configs {
+		configs[i].Number = i + 1
+		if i < len(t.WidthMin) {
+			configs[i].WidthMin = t.WidthMin[i]
+		}
+		if t.AlignHeaderLeft {
+			configs[i].AlignHeader = text.AlignLeft
+		}
+	}
+	for _, number := range t.AlignRight {
+		if number >= 1 && number <= len(configs) {
+			configs[number-1].Align = text.AlignRight
+		}
+	}
+	tw.SetColumnConfigs(configs)
+	return tw
+}
+
+func cells(row []interface{}) []string {
+	record := make([]string, 0, len(row))
+	for _, cell := range row {
+		record = append(record, fmt.Sprint(cell))
+	}
+	return record
+}
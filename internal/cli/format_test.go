@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteFormatCSV(t *testing.T) {
+	table := NewTable("Expected Milestones", "Event", "Name", "Runs")
+	table.AppendRow("bushy", "Jane DOE, Jr.", "*49")
+	table.Footer = []interface{}{"Total", "", 1}
+
+	var out bytes.Buffer
+	if err := WriteFormat(&out, FormatCSV, nil, table); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Event,Name,Runs\nbushy,\"Jane DOE, Jr.\",*49\n"
+	if out.String() != expected {
+		t.Errorf("got %q, expected %q", out.String(), expected)
+	}
+
+	if err := WriteFormat(&out, FormatCSV, nil, table, table); err == nil {
+		t.Error("expected an error for several CSV tables")
+	}
+}
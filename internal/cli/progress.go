@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	parkrun "github.com/flopp/parkrun-milestones/internal/parkrun"
+)
+
+const progressBarWidth = 30
+
+// progressBar renders the batches of a client as a progress bar; if out is not a terminal,
+// only the start and the end of each batch are printed.
+type progressBar struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+}
+
+func newProgressBar(out *os.File) *progressBar {
+	terminal := false
+	if info, err := out.Stat(); err == nil {
+		terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressBar{out: out, terminal: terminal}
+}
+
+func (bar *progressBar) Observe(progress parkrun.Progress) {
+	bar.mu.Lock()
+	defer bar.mu.Unlock()
+
+	switch progress.Kind {
+	case parkrun.BatchStarted:
+		if bar.terminal {
+			bar.render(progress)
+		} else {
+			fmt.Fprintf(bar.out, "-- Fetching %d %s...\n", progress.Total, progress.Batch)
+		}
+	case parkrun.BatchProgress:
+		if bar.terminal {
+			bar.render(progress)
+		}
+	case parkrun.BatchFinished:
+		if bar.terminal {
+			bar.render(progress)
+			fmt.Fprintln(bar.out)
+		} else {
+			fmt.Fprintf(bar.out, "-- Fetched %d/%d %s (%d downloads, %d cache hits, %d errors in total)\n",
+				progress.Done, progress.Total, progress.Batch, progress.Fetched, progress.CacheHits, progress.Errors)
+		}
+	}
+}
+
+func (bar *progressBar) render(progress parkrun.Progress) {
+	filled := progressBarWidth
+	if progress.Total > 0 {
+		filled = progressBarWidth * progress.Done / progress.Total
+	}
+	fmt.Fprintf(bar.out, "\r-- %s [%s%s] %d/%d (%d downloads, %d cache hits, %d errors)",
+		progress.Batch,
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		progress.Done, progress.Total,
+		progress.Fetched, progress.CacheHits, progress.Errors)
+}
@@ -0,0 +1,60 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+)
+
+var ErrBlocked = errors.New("blocked by parkrun (access denied or bot challenge)")
+
+// markers of the "access denied" and bot challenge pages of the common CDNs / WAFs, which do not appear on actual
+// content: titles and incident texts
+var blockPageMarkers = [][]byte{
+	[]byte("<title>access denied</title>"),
+	[]byte("you don't have permission to access"),
+	[]byte("<title>attention required! | cloudflare</title>"),
+	[]byte("<title>just a moment...</title>"),
+	[]byte("request unsuccessful. incapsula incident"),
+	[]byte("<title>pardon our interruption</title>"),
+}
+
+// markers of the scripts of the CDNs / WAFs, which are also embedded in actual content; they only mark a challenge
+// page if the page is small and has no tables
+var blockScriptMarkers = [][]byte{
+	[]byte("_incapsula_resource"),
+	[]byte("awswaf"),
+	[]byte("/cdn-cgi/challenge-platform/"),
+}
+
+// challenge pages are a few KB; actual pages are much larger
+const maxChallengePageSize = 16 * 1024
+
+// IsBlockPage reports whether buf looks like a block or challenge page instead of actual content.
+func IsBlockPage(buf []byte) bool {
+	size := len(buf)
+	// real pages are large, block pages are small; only look at the start
+	if len(buf) > 64*1024 {
+		buf = buf[:64*1024]
+	}
+	lower := bytes.ToLower(buf)
+	for _, marker := range blockPageMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	if size > maxChallengePageSize || bytes.Contains(lower, []byte("<table")) {
+		return false
+	}
+	for _, marker := range blockScriptMarkers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlockStatus(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusForbidden
+}
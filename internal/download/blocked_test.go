@@ -0,0 +1,28 @@
+package download
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsBlockPage(t *testing.T) {
+	results := `<html><head><title>Results | parkrun</title><script src="/_Incapsula_Resource?SWJIYLWA=123"></script></head>` +
+		`<body><table class="Results-table">` + strings.Repeat(`<tr><td>Jane DOE</td><td>21:05</td></tr>`, 500) + `</table></body></html>`
+	for _, test := range []struct {
+		name    string
+		page    string
+		blocked bool
+	}{
+		{"results with incapsula script", results, false},
+		{"events json", `{"events":{"type":"FeatureCollection","features":[]}}`, false},
+		{"small page with table and waf script", `<html><script>window.awsWafCookieDomainList = [];</script><table><tr><td>1</td></tr></table></html>`, false},
+		{"cloudflare challenge", `<!DOCTYPE html><html><head><title>Just a moment...</title></head><body></body></html>`, true},
+		{"incapsula incident", `<html><body>Request unsuccessful. Incapsula incident ID: 123-456</body></html>`, true},
+		{"incapsula challenge", `<html><head><meta name="robots" content="noindex"></head><body><iframe src="/_Incapsula_Resource?CWUDNSAI=1"></iframe></body></html>`, true},
+		{"aws waf challenge", `<html><head><script src="https://x.token.awswaf.com/challenge.js"></script></head><body></body></html>`, true},
+	} {
+		if got := IsBlockPage([]byte(test.page)); got != test.blocked {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.blocked)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package download
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+const GzipSuffix = ".gz"
+
+// cachedPath returns the path of the cached copy of filePath: either filePath itself or its compressed variant.
+func cachedPath(filePath string) (string, time.Time, error) {
+	plainMtime, plainErr := file.GetMtime(filePath)
+	gzMtime, gzErr := file.GetMtime(filePath + GzipSuffix)
+	switch {
+	case plainErr == nil && gzErr == nil:
+		if gzMtime.After(plainMtime) {
+			return filePath + GzipSuffix, gzMtime, nil
+		}
+		return filePath, plainMtime, nil
+	case gzErr == nil:
+		return filePath + GzipSuffix, gzMtime, nil
+	default:
+		return filePath, plainMtime, plainErr
+	}
+}
+
+func compress(buf []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func decompress(buf []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// writeCached stores buf as the cached copy of filePath and removes the other variant.
+func (downloader *Downloader) writeCached(filePath string, buf []byte) error {
+	target, other := filePath, filePath+GzipSuffix
+	if downloader.Compress {
+		compressed, err := compress(buf)
+		if err != nil {
+			return err
+		}
+		buf = compressed
+		target, other = other, target
+	}
+
+	if err := file.WriteFileAtomic(target, buf); err != nil {
+		return err
+	}
+	if err := os.Remove(other); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Mtime returns the modification time of the cached copy of filePath.
+func (downloader *Downloader) Mtime(filePath string) (time.Time, error) {
+	_, mtime, err := cachedPath(filePath)
+	return mtime, err
+}
+
+// ReadCached returns the (decompressed) content and the modification time of the cached copy of filePath, without
+// modifying the cache; for inspecting the cache.
+func ReadCached(filePath string) ([]byte, time.Time, error) {
+	buf, _, mtime, err := readCached(filePath)
+	return buf, mtime, err
+}
+
+// readCached is ReadCached, which also returns the path of the cached copy.
+func readCached(filePath string) ([]byte, string, time.Time, error) {
+	p, mtime, err := cachedPath(filePath)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	if p != filePath {
+		buf, err = decompress(buf)
+		if err != nil {
+			return nil, "", time.Time{}, err
+		}
+	}
+	return buf, p, mtime, nil
+}
+
+// Read returns the (decompressed) content and the modification time of the cached copy of filePath;
+// if compression is enabled, an uncompressed copy is migrated on the fly.
+func (downloader *Downloader) Read(filePath string) ([]byte, time.Time, error) {
+	buf, p, mtime, err := readCached(filePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if p == filePath && downloader.Compress {
+		if err := downloader.writeCached(filePath, buf); err != nil {
+			return nil, time.Time{}, err
+		}
+		if err := os.Chtimes(filePath+GzipSuffix, mtime, mtime); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return buf, mtime, nil
+}
@@ -1,10 +1,9 @@
 package download
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,55 +11,116 @@ import (
 	file "github.com/flopp/parkrun-milestones/internal/file"
 )
 
-func AlwaysDownload(url string, filePath string) error {
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+// Downloader fetches urls via Fetcher and caches the results on disk.
+type Downloader struct {
+	Fetcher Fetcher
+	// store newly downloaded files gzip-compressed
+	Compress bool
+	// never fetch anything; serve all files from the cache regardless of their age
+	Offline bool
+	Logger  *slog.Logger
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+func NewDownloader(fetcher Fetcher) *Downloader {
+	return &Downloader{Fetcher: fetcher, Logger: slog.Default()}
+}
+
+func (downloader *Downloader) logger() *slog.Logger {
+	if downloader.Logger == nil {
+		return slog.Default()
 	}
-	req.Header.Add("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/104.0.0.0 Safari/537.36")
-	client := &http.Client{}
-	response, err := client.Do(req)
+	return downloader.Logger
+}
+
+func (downloader *Downloader) AlwaysDownload(ctx context.Context, url string, filePath string) error {
+	if downloader.Offline {
+		if _, _, err := cachedPath(filePath); err == nil {
+			return nil
+		}
+		return fmt.Errorf("%w: '%s'", ErrNotCached, url)
+	}
+
+	request := &Request{Url: url}
+	// only revalidate if we still have the cached file
+	cachedFile, _, err := cachedPath(filePath)
+	if err == nil {
+		if meta, err := ReadMeta(filePath); err == nil && meta.Url == url {
+			request.ETag = meta.ETag
+			request.LastModified = meta.LastModified
+		}
+	}
+
+	logger := downloader.logger()
+	start := time.Now()
+	logger.Debug("fetching", "url", url, "conditional", request.ETag != "" || request.LastModified != "")
+	response, err := downloader.Fetcher.Fetch(ctx, request)
 	if err != nil {
+		logger.Warn("fetch failed", "url", url, "duration", time.Since(start), "err", err)
+		if isBlockStatus(err) {
+			return fmt.Errorf("%w: '%s': %w", ErrBlocked, url, err)
+		}
 		return err
 	}
-	defer response.Body.Close()
+	// never cache block pages, they would hide the actual content until they expire
+	if !response.NotModified() && IsBlockPage(response.Body) {
+		logger.Warn("blocked", "url", url, "duration", time.Since(start))
+		return fmt.Errorf("%w: '%s'", ErrBlocked, url)
+	}
 
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		return fmt.Errorf("Non-OK HTTP status: %d", response.StatusCode)
+	now := time.Now()
+	logger.Info("fetched", "url", url, "status", response.StatusCode, "bytes", len(response.Body), "duration", now.Sub(start))
+	meta := &Meta{url, response.ETag, response.LastModified, response.StatusCode, now}
+
+	if response.NotModified() {
+		// keep the validators of the cached file if the server did not repeat them
+		if meta.ETag == "" {
+			meta.ETag = request.ETag
+		}
+		if meta.LastModified == "" {
+			meta.LastModified = request.LastModified
+		}
+		if err := os.Chtimes(cachedFile, now, now); err != nil {
+			return err
+		}
+		return WriteMeta(filePath, meta)
 	}
 
 	if err := os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
 		return err
 	}
 
-	out, err := os.Create(filePath)
-	if err != nil {
+	if err := downloader.writeCached(filePath, response.Body); err != nil {
 		return err
 	}
-	defer out.Close()
-
-	_, err = io.Copy(out, response.Body)
-	return err
+	return WriteMeta(filePath, meta)
 }
 
-func DownloadFileMaxMtime(url string, filePath string, maxMtime time.Time) error {
-	mtime, err := file.GetMtime(filePath)
-	if err == nil {
-		if mtime.After(maxMtime) {
-			return nil
-		}
-	}
+// IsFresh reports whether filePath is cached and was fetched after maxMtime.
+func (downloader *Downloader) IsFresh(filePath string, maxMtime time.Time) bool {
+	mtime, err := downloader.Mtime(filePath)
+	return err == nil && mtime.After(maxMtime)
+}
 
-	return AlwaysDownload(url, filePath)
+// Lock takes the cross-process lock of filePath (see file.Lock); hold it while checking, downloading and reading
+// the file, such that concurrent processes download it only once and never see a partial update.
+func (downloader *Downloader) Lock(ctx context.Context, filePath string) (func() error, error) {
+	return file.Lock(ctx, filePath)
 }
 
-func DownloadFile(url string, filePath string, maxAge time.Duration) error {
-	if mtime, err := file.GetMtime(filePath); err == nil && mtime.After(time.Now().Add(-maxAge)) {
+func (downloader *Downloader) DownloadFileMaxMtime(ctx context.Context, url string, filePath string, maxMtime time.Time) error {
+	unlock, err := downloader.Lock(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if downloader.IsFresh(filePath, maxMtime) {
 		return nil
 	}
 
-	return AlwaysDownload(url, filePath)
+	return downloader.AlwaysDownload(ctx, url, filePath)
+}
+
+func (downloader *Downloader) DownloadFile(ctx context.Context, url string, filePath string, maxAge time.Duration) error {
+	return downloader.DownloadFileMaxMtime(ctx, url, filePath, time.Now().Add(-maxAge))
 }
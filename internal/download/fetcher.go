@@ -0,0 +1,229 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// a file is not in the cache, but Downloader.Offline forbids fetching it
+	ErrNotCached = errors.New("not cached (offline mode)")
+)
+
+type Request struct {
+	Url string
+	// validators of the cached copy for a conditional request (empty if there is none)
+	ETag         string
+	LastModified string
+}
+
+type Response struct {
+	Body         []byte
+	StatusCode   int
+	ETag         string
+	LastModified string
+}
+
+// NotModified reports whether the cached copy of a conditional request is still valid.
+func (response *Response) NotModified() bool {
+	return response.StatusCode == http.StatusNotModified
+}
+
+// A Fetcher retrieves the content of a url; the download functions of this package
+// take care of caching the result.
+type Fetcher interface {
+	Fetch(ctx context.Context, request *Request) (*Response, error)
+}
+
+// FetcherFunc adapts an ordinary function to the Fetcher interface.
+type FetcherFunc func(ctx context.Context, request *Request) (*Response, error)
+
+func (f FetcherFunc) Fetch(ctx context.Context, request *Request) (*Response, error) {
+	return f(ctx, request)
+}
+
+type HTTPFetcher struct {
+	Client    *http.Client
+	UserAgent string
+	// maximum duration of a single request (including reading the body); 0 means no timeout
+	Timeout time.Duration
+	// if set, scheme and host of all requested urls are replaced by BaseUrl (e.g. the url of a httptest.Server)
+	BaseUrl string
+}
+
+// NewHTTPFetcher creates a fetcher with certificate verification and proxy support (see NewTransport).
+func NewHTTPFetcher() *HTTPFetcher {
+	transport, _ := NewTransport(TransportOptions{})
+	return &HTTPFetcher{
+		Client:    &http.Client{Transport: transport},
+		Timeout:   60 * time.Second,
+		UserAgent: DefaultUserAgent,
+	}
+}
+
+func (fetcher *HTTPFetcher) Fetch(ctx context.Context, request *Request) (*Response, error) {
+	url := request.Url
+	if fetcher.BaseUrl != "" {
+		rebased, err := rebaseUrl(url, fetcher.BaseUrl)
+		if err != nil {
+			return nil, err
+		}
+		url = rebased
+	}
+
+	if fetcher.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fetcher.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if fetcher.UserAgent != "" {
+		req.Header.Add("user-agent", fetcher.UserAgent)
+	}
+	if request.ETag != "" {
+		req.Header.Add("if-none-match", request.ETag)
+	}
+	if request.LastModified != "" {
+		req.Header.Add("if-modified-since", request.LastModified)
+	}
+	client := fetcher.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	result := &Response{
+		StatusCode:   response.StatusCode,
+		ETag:         response.Header.Get("etag"),
+		LastModified: response.Header.Get("last-modified"),
+	}
+	if result.NotModified() {
+		return result, nil
+	}
+
+	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
+	if !statusOK {
+		return nil, &StatusError{request.Url, response.StatusCode, parseRetryAfter(response.Header.Get("Retry-After"), time.Now())}
+	}
+
+	buf, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.ContentLength >= 0 && int64(len(buf)) != response.ContentLength {
+		return nil, fmt.Errorf("%w: got %d of %d bytes from '%s'", io.ErrUnexpectedEOF, len(buf), response.ContentLength, request.Url)
+	}
+	result.Body = buf
+	return result, nil
+}
+
+func rebaseUrl(rawUrl string, baseUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	return u.String(), nil
+}
+
+// UrlPath maps a url to a relative file path "HOST/PATH"; a trailing slash of PATH is dropped,
+// e.g. "https://www.parkrun.org.uk/bushy/results/12/" becomes "www.parkrun.org.uk/bushy/results/12".
+func UrlPath(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("url without host: '%s'", rawUrl)
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	if p == "" {
+		p = "/index"
+	}
+	return filepath.FromSlash(u.Host + p), nil
+}
+
+// DirFetcher serves urls from a directory of captured files (see UrlPath for the layout).
+type DirFetcher struct {
+	Dir string
+}
+
+func (fetcher DirFetcher) Fetch(ctx context.Context, request *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := UrlPath(request.Url)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := os.ReadFile(filepath.Join(fetcher.Dir, p))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: '%s' in '%s'", ErrNotFound, request.Url, fetcher.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Body: buf, StatusCode: http.StatusOK}, nil
+}
+
+// MapFetcher serves urls from memory.
+type MapFetcher map[string][]byte
+
+func (fetcher MapFetcher) Fetch(ctx context.Context, request *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if buf, ok := fetcher[request.Url]; ok {
+		return &Response{Body: buf, StatusCode: http.StatusOK}, nil
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrNotFound, request.Url)
+}
+
+// Record returns a Fetcher that stores everything fetched by fetcher in dir, such that
+// DirFetcher{dir} can replay it later.
+func Record(fetcher Fetcher, dir string) Fetcher {
+	return FetcherFunc(func(ctx context.Context, request *Request) (*Response, error) {
+		// conditional requests would not give us anything to record
+		response, err := fetcher.Fetch(ctx, &Request{Url: request.Url})
+		if err != nil || IsBlockPage(response.Body) {
+			return response, err
+		}
+		p, err := UrlPath(request.Url)
+		if err != nil {
+			return nil, err
+		}
+		filePath := filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
+			return nil, err
+		}
+		if err := file.WriteFileAtomic(filePath, response.Body); err != nil {
+			return nil, err
+		}
+		return response, nil
+	})
+}
@@ -0,0 +1,30 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	const url = "https://www.parkrun.org.uk/bushy/results/eventhistory/"
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	recorder := Record(MapFetcher{url: []byte("history")}, dir)
+	if _, err := recorder.Fetch(ctx, &Request{Url: url}); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := DirFetcher{dir}
+	response, err := replay.Fetch(ctx, &Request{Url: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response.Body) != "history" {
+		t.Errorf("got '%s', expected 'history'", response.Body)
+	}
+	if _, err := replay.Fetch(ctx, &Request{Url: "https://www.parkrun.org.uk/bushy/results/1/"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, expected ErrNotFound", err)
+	}
+}
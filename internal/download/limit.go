@@ -0,0 +1,114 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrBudgetExceeded = errors.New("request budget exceeded")
+
+// RateLimiter is a token bucket that allows bursts of up to 'burst' requests and
+// otherwise at most one request per 'delay'; it is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	delay  time.Duration
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(delay time.Duration, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{delay: delay, burst: burst, tokens: float64(burst)}
+}
+
+func (limiter *RateLimiter) reserve() time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.delay <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	if !limiter.last.IsZero() {
+		limiter.tokens += float64(now.Sub(limiter.last)) / float64(limiter.delay)
+		if limiter.tokens > float64(limiter.burst) {
+			limiter.tokens = float64(limiter.burst)
+		}
+	}
+	limiter.last = now
+
+	// the token may be taken from the future, making concurrent callers queue up
+	limiter.tokens -= 1
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens * float64(limiter.delay))
+}
+
+// Wait blocks until the next request may be sent or ctx is done.
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	return sleep(ctx, limiter.reserve())
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Budget limits the total number of requests; it is safe for concurrent use.
+type Budget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// NewBudget creates a budget of max requests; max <= 0 means unlimited.
+func NewBudget(max int64) *Budget {
+	return &Budget{max: max}
+}
+
+func (budget *Budget) Take() error {
+	used := budget.used.Add(1)
+	if budget.max > 0 && used > budget.max {
+		return fmt.Errorf("%w: more than %d requests", ErrBudgetExceeded, budget.max)
+	}
+	return nil
+}
+
+func (budget *Budget) Used() int64 {
+	return budget.used.Load()
+}
+
+// Limit returns a Fetcher that waits for the limiter and takes from the budget before
+// each request to fetcher; both limiter and budget may be nil.
+func Limit(fetcher Fetcher, limiter *RateLimiter, budget *Budget) Fetcher {
+	return FetcherFunc(func(ctx context.Context, request *Request) (*Response, error) {
+		if budget != nil {
+			if err := budget.Take(); err != nil {
+				return nil, err
+			}
+		}
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return fetcher.Fetch(ctx, request)
+	})
+}
@@ -0,0 +1,45 @@
+package download
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+const MetaSuffix = ".meta"
+
+// Meta is the response metadata of a cached file, stored in a sidecar file next to it.
+type Meta struct {
+	Url          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	StatusCode   int       `json:"status"`
+	FetchTime    time.Time `json:"fetch_time"`
+}
+
+func MetaPath(filePath string) string {
+	return filePath + MetaSuffix
+}
+
+func ReadMeta(filePath string) (*Meta, error) {
+	buf, err := os.ReadFile(MetaPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Meta
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func WriteMeta(filePath string, meta *Meta) error {
+	buf, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return file.WriteFileAtomic(MetaPath(filePath), buf)
+}
@@ -0,0 +1,157 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// StatusError is returned by HTTPFetcher for non-2xx responses.
+type StatusError struct {
+	Url        string
+	StatusCode int
+	// the delay requested by the server's Retry-After header (0 if there is none)
+	RetryAfter time.Duration
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("Non-OK HTTP status: %d", err.StatusCode)
+}
+
+// FetchError reports a failed fetch after all retries.
+type FetchError struct {
+	Url      string
+	Attempts int
+	Err      error
+}
+
+func (err *FetchError) Error() string {
+	return fmt.Sprintf("fetching '%s' failed after %d attempt(s): %v", err.Url, err.Attempts, err.Err)
+}
+
+func (err *FetchError) Unwrap() error {
+	return err.Err
+}
+
+// StatusCode returns the HTTP status code of the last attempt, or 0 if it did not get a response.
+func (err *FetchError) StatusCode() int {
+	var statusErr *StatusError
+	if errors.As(err.Err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// IsTransient reports whether err is worth retrying: 429 and 5xx responses, timeouts and dropped connections.
+func IsTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+type RetryPolicy struct {
+	// total number of attempts, including the first one
+	Attempts   int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// give up if the server asks us to wait longer than this
+	MaxRetryAfter time.Duration
+	// logs the retries; slog.Default() if nil
+	Logger *slog.Logger
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:      4,
+	MinBackoff:    1 * time.Second,
+	MaxBackoff:    30 * time.Second,
+	MaxRetryAfter: 5 * time.Minute,
+}
+
+func (policy RetryPolicy) logger() *slog.Logger {
+	if policy.Logger == nil {
+		return slog.Default()
+	}
+	return policy.Logger
+}
+
+// backoff returns the jittered delay before the given retry (1, 2, ...).
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+	d := policy.MinBackoff
+	for i := 1; i < retry && d < policy.MaxBackoff; i += 1 {
+		d *= 2
+	}
+	if d > policy.MaxBackoff {
+		d = policy.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// "equal jitter": somewhere between d/2 and d
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Retry returns a Fetcher that retries transient failures of fetcher with exponential backoff,
+// honouring Retry-After headers; the final failure is reported as *FetchError.
+func Retry(fetcher Fetcher, policy RetryPolicy) Fetcher {
+	return FetcherFunc(func(ctx context.Context, request *Request) (*Response, error) {
+		attempt := 0
+		for {
+			attempt += 1
+			response, err := fetcher.Fetch(ctx, request)
+			if err == nil {
+				return response, nil
+			}
+			// a timeout of the overall context is not transient
+			if attempt >= policy.Attempts || ctx.Err() != nil || !IsTransient(err) {
+				return nil, &FetchError{request.Url, attempt, err}
+			}
+
+			wait := policy.backoff(attempt)
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+				if statusErr.RetryAfter > policy.MaxRetryAfter {
+					return nil, &FetchError{request.Url, attempt, err}
+				}
+				wait = statusErr.RetryAfter
+			}
+			policy.logger().Info("retrying", "url", request.Url, "attempt", attempt, "wait", wait, "err", err)
+			if err := sleep(ctx, wait); err != nil {
+				return nil, &FetchError{request.Url, attempt, err}
+			}
+		}
+	})
+}
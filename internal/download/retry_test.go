@@ -0,0 +1,85 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// flakyFetcher fails with the given errors before serving the page.
+type flakyFetcher struct {
+	errs     []error
+	attempts int
+}
+
+func (fetcher *flakyFetcher) Fetch(ctx context.Context, request *Request) (*Response, error) {
+	fetcher.attempts += 1
+	if fetcher.attempts <= len(fetcher.errs) {
+		return nil, fetcher.errs[fetcher.attempts-1]
+	}
+	return &Response{Body: []byte("page"), StatusCode: http.StatusOK}, nil
+}
+
+var testRetryPolicy = RetryPolicy{
+	Attempts:      3,
+	MinBackoff:    time.Millisecond,
+	MaxBackoff:    time.Millisecond,
+	MaxRetryAfter: time.Second,
+}
+
+func TestRetryAfter(t *testing.T) {
+	const retryAfter = 50 * time.Millisecond
+	fetcher := &flakyFetcher{errs: []error{&StatusError{"url", http.StatusTooManyRequests, retryAfter}}}
+	start := time.Now()
+	response, err := Retry(fetcher, testRetryPolicy).Fetch(context.Background(), &Request{Url: "url"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response.Body) != "page" || fetcher.attempts != 2 {
+		t.Errorf("got '%s' after %d attempts", response.Body, fetcher.attempts)
+	}
+	if elapsed := time.Since(start); elapsed < retryAfter {
+		t.Errorf("retried after %s, expected at least %s", elapsed, retryAfter)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	fetcher := &flakyFetcher{errs: []error{&StatusError{"url", http.StatusServiceUnavailable, time.Hour}}}
+	_, err := Retry(fetcher, testRetryPolicy).Fetch(context.Background(), &Request{Url: "url"})
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || fetchErr.Attempts != 1 || fetchErr.StatusCode() != http.StatusServiceUnavailable {
+		t.Errorf("got %v, expected a FetchError after 1 attempt", err)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	notFound := &StatusError{"url", http.StatusNotFound, 0}
+	fetcher := &flakyFetcher{errs: []error{notFound}}
+	if _, err := Retry(fetcher, testRetryPolicy).Fetch(context.Background(), &Request{Url: "url"}); !errors.Is(err, notFound) || fetcher.attempts != 1 {
+		t.Errorf("got %v after %d attempts; 404 is not transient", err, fetcher.attempts)
+	}
+
+	unavailable := &StatusError{"url", http.StatusServiceUnavailable, 0}
+	fetcher = &flakyFetcher{errs: []error{unavailable, unavailable, unavailable}}
+	if _, err := Retry(fetcher, testRetryPolicy).Fetch(context.Background(), &Request{Url: "url"}); !errors.Is(err, unavailable) || fetcher.attempts != 3 {
+		t.Errorf("got %v after %d attempts, expected 3", err, fetcher.attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 10, 12, 9, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-1":                            0,
+		"Sat, 12 Oct 2024 09:01:30 GMT": 90 * time.Second,
+		"Sat, 12 Oct 2024 08:00:00 GMT": 0,
+		"soon":                          0,
+	} {
+		if got := parseRetryAfter(value, now); got != expected {
+			t.Errorf("'%s': got %s, expected %s", value, got, expected)
+		}
+	}
+}
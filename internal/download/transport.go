@@ -0,0 +1,45 @@
+package download
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const DefaultUserAgent = "parkrun-milestones (+https://github.com/flopp/parkrun-milestones)"
+
+type TransportOptions struct {
+	// PEM file with additional trusted CA certificates (on top of the system's ones)
+	CAFile string
+	// skip the verification of server certificates; only for debugging
+	Insecure bool
+}
+
+// NewTransport creates a http.Transport that verifies server certificates (unless options.Insecure is set)
+// and uses the proxies configured by HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func NewTransport(options TransportOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle '%s'", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	config.InsecureSkipVerify = options.Insecure
+	transport.TLSClientConfig = config
+
+	return transport, nil
+}
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockSuffix       = ".lock"
+	lockPollInterval = 50 * time.Millisecond
+)
+
+// LockPath returns the path of the lock file guarding filePath, ".BASE.lock" in the same directory.
+func LockPath(filePath string) string {
+	dir, base := filepath.Split(filePath)
+	return filepath.Join(dir, "."+base+lockSuffix)
+}
+
+// Lock takes an exclusive advisory lock on filePath, waiting until it is available or ctx is done; the lock is
+// shared with other processes (and goroutines of this process) that lock the same path. Call the returned
+// function to release it.
+func Lock(ctx context.Context, filePath string) (func() error, error) {
+	lockPath := LockPath(filePath)
+	for {
+		f, err := lockFile(ctx, lockPath)
+		if err != nil {
+			return nil, err
+		}
+		// the lock file may have been removed by RemoveLock while we were waiting for it; then the lock is worthless
+		// and we have to lock the new file
+		fileInfo, err := f.Stat()
+		if err != nil {
+			release(f)
+			return nil, err
+		}
+		pathInfo, err := os.Stat(lockPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			release(f)
+			return nil, err
+		}
+		if err != nil || !os.SameFile(fileInfo, pathInfo) {
+			release(f)
+			continue
+		}
+		return func() error {
+			return release(f)
+		}, nil
+	}
+}
+
+// RemoveLock removes the lock file of filePath, such that it does not pile up after the file has been removed;
+// the caller must hold the lock.
+func RemoveLock(filePath string) error {
+	if err := os.Remove(LockPath(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func lockFile(ctx context.Context, lockPath string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0770); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0660)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return f, nil
+		}
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			f.Close()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func release(f *os.File) error {
+	err := unlock(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+//go:build !unix
+
+package file
+
+import (
+	"os"
+	"sync"
+)
+
+// without flock, files are only locked against other goroutines of this process
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]bool)
+)
+
+func tryLock(f *os.File) (bool, error) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if locks[f.Name()] {
+		return false, nil
+	}
+	locks[f.Name()] = true
+	return true, nil
+}
+
+func unlock(f *os.File) error {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	delete(locks, f.Name())
+	return nil
+}
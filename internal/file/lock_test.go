@@ -0,0 +1,59 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveLock(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "page")
+	ctx := context.Background()
+
+	unlock, err := Lock(ctx, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a waiter for the lock file that is about to be removed
+	locked := make(chan func() error)
+	go func() {
+		unlock, err := Lock(ctx, filePath)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlock
+	}()
+	time.Sleep(2 * lockPollInterval)
+
+	if err := RemoveLock(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlockWaiter := <-locked
+
+	// the waiter holds the lock of the new lock file, so nobody else may get it
+	ctxTimeout, cancel := context.WithTimeout(ctx, 3*lockPollInterval)
+	defer cancel()
+	if _, err := Lock(ctxTimeout, filePath); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the lock to be taken, got %v", err)
+	}
+	if _, err := os.Stat(LockPath(filePath)); err != nil {
+		t.Errorf("expected a new lock file: %v", err)
+	}
+
+	if err := RemoveLock(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlockWaiter(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(LockPath(filePath)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the lock file to be removed: %v", err)
+	}
+}
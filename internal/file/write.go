@@ -0,0 +1,93 @@
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const partialSuffix = ".part"
+
+// WriteFileAtomic writes buf to a temporary file next to filePath, which is renamed to filePath once complete;
+// readers therefore either see the old or the new file, but never a truncated one.
+func WriteFileAtomic(filePath string, buf []byte) error {
+	dir, base := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	out, err := os.CreateTemp(dir, "."+base+".*"+partialSuffix)
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+
+	_, err = out.Write(buf)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0660)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// PartialFileTarget returns the name of the file that the temporary file name belongs to, if name is one of the
+// temporary files of WriteFileAtomic: "." + target + "." + random digits + ".part".
+func PartialFileTarget(name string) (string, bool) {
+	if !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, partialSuffix) {
+		return "", false
+	}
+	name = strings.TrimSuffix(name[1:], partialSuffix)
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return "", false
+	}
+	target, random := name[:i], name[i+1:]
+	if target == "" || random == "" || strings.Trim(random, "0123456789") != "" {
+		return "", false
+	}
+	return target, true
+}
+
+// RemovePartialFiles deletes the leftovers of interrupted WriteFileAtomic calls in dir (not in its subdirs) that are
+// older than minAge (younger ones might still be in use by other processes) and whose target is accepted by accept.
+func RemovePartialFiles(dir string, minAge time.Duration, accept func(target string) bool) (int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	limit := time.Now().Add(-minAge)
+	for _, f := range files {
+		target, ok := PartialFileTarget(f.Name())
+		if f.IsDir() || !ok || !accept(target) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil || !info.ModTime().Before(limit) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		removed += 1
+	}
+	return removed, nil
+}
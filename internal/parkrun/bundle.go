@@ -0,0 +1,241 @@
+package parkrun
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+// CacheFilter selects cache entries for export; the zero value selects everything.
+// Profiles can't be attributed to events, so they are only selected if neither EventIds nor Country are given.
+type CacheFilter struct {
+	EventIds []string
+	// country name or url
+	Country string
+	// fetch time range; zero values mean unbounded
+	Since time.Time
+	Until time.Time
+}
+
+func (filter CacheFilter) Matches(entry *CacheEntry, countries map[string]string) bool {
+	if !filter.Since.IsZero() && entry.Mtime.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && entry.Mtime.After(filter.Until) {
+		return false
+	}
+
+	switch entry.Kind {
+	case CacheEvents:
+		return true
+	case CacheProfile, CacheUnknown:
+		return len(filter.EventIds) == 0 && filter.Country == ""
+	}
+
+	if len(filter.EventIds) > 0 {
+		found := false
+		for _, eventId := range filter.EventIds {
+			if eventId == entry.EventId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.Country != "" {
+		lowerCountry := strings.TrimSpace(strings.ToLower(filter.Country))
+		if strings.ToLower(entry.CountryUrl) != lowerCountry && strings.ToLower(countries[entry.CountryUrl]) != lowerCountry {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportCache writes the files of the given entries as a tar archive to w, preserving their modification times.
+func (client *Client) ExportCache(w io.Writer, entries []*CacheEntry) error {
+	root, err := client.CachePath("")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		// the page must precede its metadata, see ImportCache
+		files := append([]string(nil), entry.Files...)
+		sort.SliceStable(files, func(i, j int) bool {
+			return !strings.HasSuffix(files[i], download.MetaSuffix) && strings.HasSuffix(files[j], download.MetaSuffix)
+		})
+		for _, f := range files {
+			if err := exportFile(tw, root, f); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+func exportFile(tw *tar.Writer, root string, filePath string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(rel),
+		Size:     info.Size(),
+		Mode:     0660,
+		ModTime:  info.ModTime(),
+		// PAX keeps sub-second modification times
+		Format: tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(tw, in)
+	return err
+}
+
+// ImportCache reads a tar archive created by ExportCache into the cache; a page is only imported if it is newer than
+// the cached copy, and files that are not pages of the cache (or their metadata) are ignored. The stored records of
+// the imported pages are removed. It returns the numbers of imported and skipped pages.
+func (client *Client) ImportCache(r io.Reader) (int, int, error) {
+	root, err := client.CachePath("")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	downloader := client.downloader()
+	imported, skipped := 0, 0
+	importedEntries := make([]*CacheEntry, 0)
+	decisions := make(map[string]bool)
+	// importEntry imports a single file of the bundle while holding the lock of its page
+	importEntry := func(tr *tar.Reader, header *tar.Header) error {
+		isMeta := strings.HasSuffix(header.Name, download.MetaSuffix)
+		name := strings.TrimSuffix(strings.TrimSuffix(header.Name, download.MetaSuffix), download.GzipSuffix)
+		entry := &CacheEntry{Name: name, client: client}
+		classifyCacheEntry(entry)
+		if entry.Kind == CacheUnknown {
+			// never overwrite the store, lock files or files of others
+			client.logger().Warn("ignoring unknown file of cache bundle", "name", header.Name)
+			return nil
+		}
+		filePath := filepath.Join(root, filepath.FromSlash(name))
+
+		unlock, err := downloader.Lock(context.Background(), filePath)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		doImport, decided := decisions[name]
+		if !decided {
+			if isMeta {
+				// metadata without a page
+				return nil
+			}
+			mtime, err := downloader.Mtime(filePath)
+			doImport = err != nil || mtime.Before(header.ModTime)
+			decisions[name] = doImport
+			if doImport {
+				imported += 1
+				importedEntries = append(importedEntries, entry)
+				// the metadata of the old copy doesn't apply anymore; the bundle's metadata follows the page
+				if err := os.Remove(download.MetaPath(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			} else {
+				skipped += 1
+			}
+		}
+		if !doImport {
+			return nil
+		}
+
+		return importFile(tr, header, root, filePath)
+	}
+
+	err = readBundle(r, importEntry)
+	// also for the pages imported before an error
+	if client.Store != nil && len(importedEntries) > 0 {
+		if removeErr := client.Store.RemoveRecords(importedEntries); err == nil {
+			err = removeErr
+		}
+	}
+	return imported, skipped, err
+}
+
+func readBundle(r io.Reader, importEntry func(tr *tar.Reader, header *tar.Header) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("bad file name in cache bundle: '%s'", header.Name)
+		}
+		if err := importEntry(tr, header); err != nil {
+			return err
+		}
+	}
+}
+
+func importFile(tr *tar.Reader, header *tar.Header, root string, filePath string) error {
+	buf, err := io.ReadAll(tr)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(header.Name))
+	if err := os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+		return err
+	}
+	if err := file.WriteFileAtomic(target, buf); err != nil {
+		return err
+	}
+	if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+		return err
+	}
+
+	// remove the other (outdated) variant of the page
+	other := ""
+	if target == filePath {
+		other = filePath + download.GzipSuffix
+	} else if target == filePath+download.GzipSuffix {
+		other = filePath
+	}
+	if other != "" {
+		if err := os.Remove(other); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
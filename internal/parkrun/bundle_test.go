@@ -0,0 +1,125 @@
+package parkrun
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCachedPage(t *testing.T, client *Client, name string, content string, mtime time.Time) {
+	t.Helper()
+	filePath, err := client.CachePath(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte(content), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readCachedPage(t *testing.T, client *Client, name string) string {
+	t.Helper()
+	filePath, err := client.CachePath(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestImportCacheNewestWins(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	source := newTestClient(t, nil)
+	writeCachedPage(t, source, "parkrunner/1", "bundle 1", now.Add(-time.Hour))
+	writeCachedPage(t, source, "parkrunner/2", "bundle 2", now.Add(-time.Hour))
+	writeCachedPage(t, source, "parkrunner/3", "bundle 3", now.Add(-time.Hour))
+	entries, err := source.CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle bytes.Buffer
+	if err := source.ExportCache(&bundle, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestClient(t, nil)
+	// older than the bundle's copy
+	writeCachedPage(t, target, "parkrunner/1", "cached 1", now.Add(-2*time.Hour))
+	// newer than the bundle's copy
+	writeCachedPage(t, target, "parkrunner/2", "cached 2", now)
+	imported, skipped, err := target.ImportCache(&bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 || skipped != 1 {
+		t.Errorf("got %d imported and %d skipped pages, expected 2 and 1", imported, skipped)
+	}
+
+	for name, expected := range map[string]string{"parkrunner/1": "bundle 1", "parkrunner/2": "cached 2", "parkrunner/3": "bundle 3"} {
+		if got := readCachedPage(t, target, name); got != expected {
+			t.Errorf("%s: got '%s', expected '%s'", name, got, expected)
+		}
+	}
+	// imported pages keep their original fetch time
+	if mtime, err := target.downloader().Mtime(filepath.Join(target.CacheDir, "parkrunner", "3")); err != nil || !mtime.Equal(now.Add(-time.Hour)) {
+		t.Errorf("got mtime %s (%v), expected %s", mtime, err, now.Add(-time.Hour))
+	}
+}
+
+func TestImportCacheIgnoresUnknownFiles(t *testing.T) {
+	var bundle bytes.Buffer
+	tw := tar.NewWriter(&bundle)
+	for _, name := range []string{StoreFileName, "parkrunner/.1.lock", "notes.txt", "other/dir/file", "parkrunner/1"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: 3, Mode: 0660, ModTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte("bad")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, nil)
+	store, err := OpenStore(filepath.Join(client.CacheDir, StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	client.Store = store
+	if err := store.put(bucketParkrunners, "1", ParkrunnerProfile{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, skipped, err := client.ImportCache(&bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 1 || skipped != 0 {
+		t.Errorf("got %d imported and %d skipped pages, expected 1 and 0", imported, skipped)
+	}
+	// the lock file of the imported page is the one of the importer, not the one of the bundle
+	for _, name := range []string{"notes.txt", "other/dir/file", "parkrunner/.1.lock"} {
+		if data, err := os.ReadFile(filepath.Join(client.CacheDir, name)); err == nil && string(data) == "bad" {
+			t.Errorf("%s has been imported", name)
+		}
+	}
+	// the stored record would hide the imported page
+	var profile ParkrunnerProfile
+	if found, err := store.get(bucketParkrunners, "1", &profile); err != nil || found {
+		t.Errorf("expected the record to be removed: found %v, err %v", found, err)
+	}
+}
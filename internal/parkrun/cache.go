@@ -0,0 +1,278 @@
+package parkrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flopp/go-parkrunparser"
+	download "github.com/flopp/parkrun-milestones/internal/download"
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+type CacheEntryKind int
+
+const (
+	CacheUnknown CacheEntryKind = iota
+	CacheEvents
+	CacheEventHistory
+	CacheResults
+	CacheProfile
+)
+
+func (kind CacheEntryKind) String() string {
+	switch kind {
+	case CacheEvents:
+		return "events"
+	case CacheEventHistory:
+		return "eventhistory"
+	case CacheResults:
+		return "results"
+	case CacheProfile:
+		return "profile"
+	}
+	return "unknown"
+}
+
+// CacheEntry is a cached page, together with its metadata sidecar.
+type CacheEntry struct {
+	Kind CacheEntryKind
+	// the file name relative to the cache dir (as passed to CachePath)
+	Name         string
+	CountryUrl   string
+	EventId      string
+	RunIndex     uint64
+	ParkrunnerId string
+	// all files of the entry (page, compressed page, metadata)
+	Files  []string
+	Size   int64
+	Mtime  time.Time
+	client *Client
+}
+
+func (entry *CacheEntry) Age() time.Duration {
+	return time.Since(entry.Mtime)
+}
+
+func classifyCacheEntry(entry *CacheEntry) {
+	parts := strings.Split(entry.Name, "/")
+	for _, part := range parts {
+		// lock files, partial downloads
+		if part == "" || isTemporaryCacheFile(part) {
+			return
+		}
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "events.json":
+		entry.Kind = CacheEvents
+	case len(parts) == 2 && parts[0] == "parkrunner":
+		entry.Kind = CacheProfile
+		entry.ParkrunnerId = parts[1]
+	case len(parts) == 3 && !isCountryUrl(parts[0]):
+		// e.g. an unrelated dir of a shared cache dir
+	case len(parts) == 3 && parts[2] == "eventhistory":
+		entry.Kind = CacheEventHistory
+		entry.CountryUrl = parts[0]
+		entry.EventId = parts[1]
+	case len(parts) == 3:
+		if index, err := strconv.ParseUint(parts[2], 10, 64); err == nil {
+			entry.Kind = CacheResults
+			entry.CountryUrl = parts[0]
+			entry.EventId = parts[1]
+			entry.RunIndex = index
+		}
+	}
+}
+
+func isCountryUrl(s string) bool {
+	return strings.Contains(s, "parkrun.")
+}
+
+func isTemporaryCacheFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// CacheEntries lists all pages in the cache, sorted by name; files that are not pages of the cache are ignored.
+func (client *Client) CacheEntries() ([]*CacheEntry, error) {
+	root, err := client.CachePath("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*CacheEntry)
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			// the cache dir may be shared with other files, so only the known layout is walked:
+			// parkrunner/ID and COUNTRYURL/EVENTID/PAGE
+			if p != root && (isTemporaryCacheFile(d.Name()) || strings.Count(name, "/") >= 2) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isTemporaryCacheFile(d.Name()) {
+			return nil
+		}
+
+		isMeta := strings.HasSuffix(name, download.MetaSuffix)
+		name = strings.TrimSuffix(name, download.MetaSuffix)
+		name = strings.TrimSuffix(name, download.GzipSuffix)
+
+		entry, found := entries[name]
+		if !found {
+			entry = &CacheEntry{Name: name, client: client}
+			classifyCacheEntry(entry)
+			if entry.Kind == CacheUnknown {
+				// not ours, e.g. the store
+				return nil
+			}
+			entries[name] = entry
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry.Files = append(entry.Files, p)
+		entry.Size += info.Size()
+		if !isMeta && info.ModTime().After(entry.Mtime) {
+			entry.Mtime = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*CacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+var (
+	ErrUnknownCacheFile = errors.New("unknown cache file")
+	// the cached page cannot be parsed; see CacheEntry.Verify
+	ErrBadPage = errors.New("bad page")
+)
+
+func (entry *CacheEntry) Remove() error {
+	// never delete files that do not belong to the cache
+	if entry.Kind == CacheUnknown {
+		return fmt.Errorf("while removing '%s': %w", entry.Name, ErrUnknownCacheFile)
+	}
+	filePath, err := entry.client.CachePath(entry.Name)
+	if err != nil {
+		return err
+	}
+	unlock, err := entry.client.downloader().Lock(context.Background(), filePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, f := range entry.Files {
+		if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return file.RemoveLock(filePath)
+}
+
+// inspectCached reads the cached page while holding its lock, but leaves the cache as it was: the page is not
+// migrated (see Downloader.Read), and a lock file created for reading is removed again.
+func (client *Client) inspectCached(filePath string) ([]byte, error) {
+	_, lockErr := os.Stat(file.LockPath(filePath))
+	unlock, err := client.downloader().Lock(context.Background(), filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	buf, _, err := download.ReadCached(filePath)
+	if errors.Is(lockErr, fs.ErrNotExist) {
+		if removeErr := file.RemoveLock(filePath); err == nil {
+			err = removeErr
+		}
+	}
+	return buf, err
+}
+
+// Verify checks that the cached page can still be parsed; the error wraps ErrBadPage if it can't, and reports
+// a problem reading the page otherwise.
+func (entry *CacheEntry) Verify() error {
+	filePath, err := entry.client.CachePath(entry.Name)
+	if err != nil {
+		return err
+	}
+	buf, err := entry.client.inspectCached(filePath)
+	if err != nil {
+		return err
+	}
+	if download.IsBlockPage(buf) {
+		return fmt.Errorf("while verifying '%s': %w: %w", entry.Name, ErrBadPage, download.ErrBlocked)
+	}
+
+	switch entry.Kind {
+	case CacheEvents:
+		_, err = parkrunparser.ParseEvents(buf)
+	case CacheEventHistory:
+		_, err = parkrunparser.ParseEventHistory(buf)
+	case CacheResults:
+		_, err = parkrunparser.ParseResults(buf)
+	case CacheProfile:
+		// only the header data is required; see ParseParkrunnerProfile
+		var id string
+		_, id, _, _, _, err = ExtractData(string(buf))
+		if err == nil && id != entry.ParkrunnerId {
+			err = fmt.Errorf("ID mismatch: expected %s, got %s", entry.ParkrunnerId, id)
+		}
+	default:
+		return fmt.Errorf("while verifying '%s': %w", entry.Name, ErrUnknownCacheFile)
+	}
+	if err != nil {
+		return fmt.Errorf("while verifying '%s': %w: %w", entry.Name, ErrBadPage, err)
+	}
+	return nil
+}
+
+// CachedCountries maps the country urls to the country names of the cached events list, without downloading anything.
+func (client *Client) CachedCountries() map[string]string {
+	countries := make(map[string]string)
+	filePath, err := client.CachePath("events.json")
+	if err != nil {
+		return countries
+	}
+	buf, err := client.inspectCached(filePath)
+	if err != nil {
+		return countries
+	}
+	parsed_events, err := parkrunparser.ParseEvents(buf)
+	if err != nil {
+		return countries
+	}
+	for _, e := range parsed_events.Events {
+		countries[e.Country.Url] = e.Country.Name()
+	}
+	return countries
+}
@@ -0,0 +1,161 @@
+package parkrun
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestVerifyDoesNotModifyCache(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient()
+	client.CacheDir = dir
+	client.Compress = true
+
+	profile := filepath.Join(dir, "parkrunner", "1234567")
+	if err := os.MkdirAll(filepath.Dir(profile), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(profile, []byte(readFixture(t, "profile.html")), 0660); err != nil {
+		t.Fatal(err)
+	}
+	// not part of the cache
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	before := listFiles(t, dir)
+
+	entries, err := client.CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != CacheProfile {
+		t.Fatalf("expected only the profile, got %+v", entries)
+	}
+	if err := entries[0].Verify(); err != nil {
+		t.Error(err)
+	}
+	client.CachedCountries()
+
+	after := listFiles(t, dir)
+	if len(before) != len(after) {
+		t.Fatalf("cache changed from %v to %v", before, after)
+	}
+	for i := range before {
+		if before[i] != after[i] {
+			t.Fatalf("cache changed from %v to %v", before, after)
+		}
+	}
+
+	if err := entries[0].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(t, dir); len(files) != 1 || files[0] != "notes.txt" {
+		t.Errorf("expected only the unrelated file to be left, got %v", files)
+	}
+}
+
+func TestVerifyBadPage(t *testing.T) {
+	client := newTestClient(t, nil)
+	profile := filepath.Join(client.CacheDir, "parkrunner", "1234567")
+	if err := os.MkdirAll(filepath.Dir(profile), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(profile, []byte("<html>maintenance</html>"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := client.CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the profile, got %+v", entries)
+	}
+	if err := entries[0].Verify(); !errors.Is(err, ErrBadPage) {
+		t.Errorf("got %v, expected %v", err, ErrBadPage)
+	}
+
+	// a page that cannot be read is not a bad page
+	if err := os.Remove(profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := entries[0].Verify(); err == nil || errors.Is(err, ErrBadPage) {
+		t.Errorf("got %v, expected a read error", err)
+	}
+}
+
+func TestRemovePartialDownloads(t *testing.T) {
+	client := newTestClient(t, nil)
+	old := time.Now().Add(-2 * time.Hour)
+	files := map[string]bool{
+		".events.json.123.part":                 true,
+		"parkrunner/.1234567.456.part":          true,
+		"parkrunner/.1234567.meta.789.part":     true,
+		"www.parkrun.org.uk/bushy/.902.12.part": true,
+		// not partial downloads of cache pages
+		".notes.txt.123.part":               false,
+		"parkrunner/.1234567.lock":          false,
+		"parkrunner/.1234567.tmp.part":      false,
+		"other/dir/.902.12.part":            false,
+		"www.parkrun.org.uk/.bushy.12.part": false,
+	}
+	for name := range files {
+		filePath := filepath.Join(client.CacheDir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0770); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte("partial"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filePath, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// possibly still being written
+	young := filepath.Join(client.CacheDir, "parkrunner", ".7654321.1.part")
+	if err := os.WriteFile(young, []byte("partial"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := client.RemovePartialDownloads(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 4 {
+		t.Errorf("got %d removed files, expected 4", removed)
+	}
+	for name, remove := range files {
+		_, err := os.Stat(filepath.Join(client.CacheDir, name))
+		if remove != errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected removed = %v, got %v", name, remove, err)
+		}
+	}
+	if _, err := os.Stat(young); err != nil {
+		t.Errorf("young partial download: %v", err)
+	}
+}
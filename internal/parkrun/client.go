@@ -0,0 +1,76 @@
+package parkrun
+
+import (
+	"log/slog"
+	"net/http"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+)
+
+// Client holds the configuration for accessing parkrun; differently configured clients may be used side by side.
+// Events obtained from a client use that client for all further downloads.
+type Client struct {
+	// root of the cache; if empty, the user's cache dir is used
+	CacheDir  string
+	Freshness FreshnessPolicy
+	// HTTPClient and UserAgent are only used if Fetcher is nil
+	HTTPClient *http.Client
+	UserAgent  string
+	Fetcher    download.Fetcher
+	// store newly downloaded pages gzip-compressed
+	Compress bool
+	// serve all pages from the cache regardless of their age and never fetch anything
+	Offline bool
+	// parsed records are taken from and saved to Store (if not nil) instead of parsing cached pages again
+	Store *Store
+	// maximum number of concurrent downloads; the rate limit of Fetcher still applies
+	Parallelism int
+	// host of the parkrunner profile pages
+	ProfileHost string
+	Logger      *slog.Logger
+	// receives progress events, may be nil
+	Observer Observer
+	counts   progressCounts
+}
+
+func NewClient() *Client {
+	fetcher := download.NewHTTPFetcher()
+	return &Client{
+		Freshness:   DefaultFreshness,
+		HTTPClient:  fetcher.Client,
+		UserAgent:   fetcher.UserAgent,
+		Parallelism: 4,
+		ProfileHost: "www.parkrun.org.uk",
+		Logger:      slog.Default(),
+	}
+}
+
+// NewHTTPFetcher creates a fetcher using the client's HTTPClient and UserAgent, e.g. to be wrapped by download.Limit
+// and used as the client's Fetcher.
+func (client *Client) NewHTTPFetcher() *download.HTTPFetcher {
+	fetcher := download.NewHTTPFetcher()
+	if client.HTTPClient != nil {
+		fetcher.Client = client.HTTPClient
+	}
+	fetcher.UserAgent = client.UserAgent
+	return fetcher
+}
+
+func (client *Client) downloader() *download.Downloader {
+	var fetcher download.Fetcher = client.Fetcher
+	if fetcher == nil {
+		fetcher = client.NewHTTPFetcher()
+	}
+	downloader := download.NewDownloader(fetcher)
+	downloader.Compress = client.Compress
+	downloader.Offline = client.Offline
+	downloader.Logger = client.logger()
+	return downloader
+}
+
+func (client *Client) logger() *slog.Logger {
+	if client.Logger == nil {
+		return slog.Default()
+	}
+	return client.Logger
+}
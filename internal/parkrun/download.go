@@ -0,0 +1,118 @@
+package parkrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+func (client *Client) CachePath(format string, a ...any) (string, error) {
+	if client.CacheDir != "" {
+		return path.Join(client.CacheDir, fmt.Sprintf(format, a...)), nil
+	}
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(base, "parkrun-milestones", fmt.Sprintf(format, a...)), nil
+}
+
+// RemovePartialDownloads deletes the leftovers of interrupted downloads of cache pages that are older than minAge
+// and returns their number; only the dirs of the known cache layout are searched.
+func (client *Client) RemovePartialDownloads(minAge time.Duration) (int, error) {
+	root, err := client.CachePath("")
+	if err != nil {
+		return 0, err
+	}
+
+	dirs := []string{"", "parkrunner"}
+	countries, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	for _, country := range countries {
+		if !country.IsDir() || !isCountryUrl(country.Name()) || isTemporaryCacheFile(country.Name()) {
+			continue
+		}
+		events, err := os.ReadDir(filepath.Join(root, country.Name()))
+		if err != nil {
+			return 0, err
+		}
+		for _, event := range events {
+			if event.IsDir() && !isTemporaryCacheFile(event.Name()) {
+				dirs = append(dirs, country.Name()+"/"+event.Name())
+			}
+		}
+	}
+
+	removed := 0
+	for _, dir := range dirs {
+		n, err := file.RemovePartialFiles(filepath.Join(root, dir), minAge, func(target string) bool {
+			name := path.Join(dir, target)
+			name = strings.TrimSuffix(name, download.MetaSuffix)
+			name = strings.TrimSuffix(name, download.GzipSuffix)
+			entry := &CacheEntry{Name: name}
+			classifyCacheEntry(entry)
+			return entry.Kind != CacheUnknown
+		})
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (client *Client) DownloadAndRead(ctx context.Context, url string, fileName string, maxAge time.Duration) ([]byte, time.Time, error) {
+	buf, mtime, err := client.DownloadAndReadMaxMtime(ctx, url, fileName, time.Now().Add(-maxAge))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("while downloading '%s' to '%s': %w", url, fileName, err)
+	}
+	return buf, mtime, nil
+}
+
+func (client *Client) DownloadAndReadMaxMtime(ctx context.Context, url string, fileName string, maxMtime time.Time) ([]byte, time.Time, error) {
+	filePath, err := client.CachePath(fileName)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if client.Offline {
+		// any cached copy will do
+		maxMtime = time.Time{}
+	}
+
+	downloader := client.downloader()
+	// another process may be fetching the same page; wait for it and use its result
+	unlock, err := downloader.Lock(ctx, filePath)
+	if err != nil {
+		return nil, time.Time{}, contextError(ctx, err)
+	}
+	defer unlock()
+
+	if mtime, err := downloader.Mtime(filePath); err == nil && mtime.After(maxMtime) {
+		client.logger().Debug("cache hit", "url", url, "file", fileName, "fetched", mtime)
+		client.notify(ctx, CacheHit, url, nil)
+	} else {
+		client.logger().Debug("cache miss", "url", url, "file", fileName, "cached", err == nil, "fetched", mtime, "max_mtime", maxMtime)
+		client.notify(ctx, FetchStarted, url, nil)
+		if err := downloader.AlwaysDownload(ctx, url, filePath); err != nil {
+			client.notify(ctx, FetchFailed, url, err)
+			return nil, time.Time{}, contextError(ctx, err)
+		}
+		client.notify(ctx, FetchFinished, url, nil)
+	}
+
+	return downloader.Read(filePath)
+}
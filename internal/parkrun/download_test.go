@@ -0,0 +1,105 @@
+package parkrun
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+)
+
+// countingFetcher serves pages from memory and counts the fetches.
+type countingFetcher struct {
+	pages   download.MapFetcher
+	fetches int
+}
+
+func (fetcher *countingFetcher) Fetch(ctx context.Context, request *download.Request) (*download.Response, error) {
+	fetcher.fetches += 1
+	return fetcher.pages.Fetch(ctx, request)
+}
+
+func newTestClient(t *testing.T, fetcher download.Fetcher) *Client {
+	t.Helper()
+	client := NewClient()
+	client.CacheDir = t.TempDir()
+	client.Fetcher = fetcher
+	return client
+}
+
+func TestDownloadAndReadMaxMtime(t *testing.T) {
+	const url = "https://www.parkrun.org.uk/parkrunner/1234567/"
+	fetcher := &countingFetcher{pages: download.MapFetcher{url: []byte("v1")}}
+	client := newTestClient(t, fetcher)
+	ctx := context.Background()
+
+	read := func(maxMtime time.Time, expected string, expectedFetches int) time.Time {
+		t.Helper()
+		buf, mtime, err := client.DownloadAndReadMaxMtime(ctx, url, "parkrunner/1234567", maxMtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != expected || fetcher.fetches != expectedFetches {
+			t.Fatalf("got '%s' after %d fetches, expected '%s' after %d", buf, fetcher.fetches, expected, expectedFetches)
+		}
+		return mtime
+	}
+
+	fetched := read(time.Now().Add(-time.Hour), "v1", 1)
+	// fresh enough: served from the cache
+	fetcher.pages[url] = []byte("v2")
+	if mtime := read(fetched.Add(-time.Second), "v1", 1); !mtime.Equal(fetched) {
+		t.Errorf("got mtime %s, expected %s", mtime, fetched)
+	}
+	// fetched before maxMtime: fetched again
+	read(fetched, "v2", 2)
+}
+
+func TestDownloadAndReadNotFound(t *testing.T) {
+	client := newTestClient(t, download.MapFetcher{})
+	_, _, err := client.DownloadAndRead(context.Background(), "https://www.parkrun.org.uk/parkrunner/1/", "parkrunner/1", time.Hour)
+	if !errors.Is(err, download.ErrNotFound) {
+		t.Errorf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestDownloadAndReadOffline(t *testing.T) {
+	const url = "https://www.parkrun.org.uk/parkrunner/1234567/"
+	fetcher := &countingFetcher{pages: download.MapFetcher{url: []byte("v1")}}
+	client := newTestClient(t, fetcher)
+	ctx := context.Background()
+	if _, _, err := client.DownloadAndReadMaxMtime(ctx, url, "parkrunner/1234567", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// any cached copy will do, however old
+	client.Offline = true
+	buf, _, err := client.DownloadAndReadMaxMtime(ctx, url, "parkrunner/1234567", time.Now().Add(time.Hour))
+	if err != nil || string(buf) != "v1" {
+		t.Errorf("got '%s' (%v), expected the cached copy", buf, err)
+	}
+	_, _, err = client.DownloadAndReadMaxMtime(ctx, url+"x", "parkrunner/1234568", time.Time{})
+	if !errors.Is(err, ErrNotCached) {
+		t.Errorf("got %v, expected ErrNotCached for an uncached page", err)
+	}
+	if fetcher.fetches != 1 {
+		t.Errorf("fetched %d times in offline mode", fetcher.fetches-1)
+	}
+}
+
+func TestDownloadAndReadCancelCause(t *testing.T) {
+	errDeadline := errors.New("deadline reached")
+	// a request that runs into the deadline fails like the timeout of a single request
+	client := newTestClient(t, download.FetcherFunc(func(ctx context.Context, request *download.Request) (*download.Response, error) {
+		<-ctx.Done()
+		return nil, &download.FetchError{Url: request.Url, Attempts: 1, Err: context.DeadlineExceeded}
+	}))
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Millisecond, errDeadline)
+	defer cancel()
+
+	_, _, err := client.DownloadAndRead(ctx, "https://www.parkrun.org.uk/parkrunner/1234567/", "parkrunner/1234567", time.Hour)
+	if !errors.Is(err, errDeadline) {
+		t.Errorf("got %v, expected the cause %v", err, errDeadline)
+	}
+}
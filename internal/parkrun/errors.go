@@ -0,0 +1,53 @@
+package parkrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	download "github.com/flopp/parkrun-milestones/internal/download"
+)
+
+var (
+	ErrEventNotFound = errors.New("event not found")
+	ErrBadRunIndex   = errors.New("bad run index")
+	// parkrun answered with a block or challenge page instead of the requested content
+	ErrBlocked = download.ErrBlocked
+	// the page is not cached, but the client is offline
+	ErrNotCached = download.ErrNotCached
+)
+
+// ParseError reports a downloaded page that does not have the expected content.
+type ParseError struct {
+	// what was parsed, e.g. "results of bushy #902"
+	What      string
+	Url       string
+	CachePath string
+	Err       error
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("cannot parse %s from '%s' (cached at '%s'): %v", err.What, err.Url, err.CachePath, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+func (client *Client) parseError(what string, url string, fileName string, err error) error {
+	cachePath, pathErr := client.CachePath(fileName)
+	if pathErr != nil {
+		cachePath = fileName
+	}
+	client.logger().Warn("parse failed", "what", what, "url", url, "file", cachePath, "err", err)
+	return &ParseError{what, url, cachePath, err}
+}
+
+// contextError adds the cause of the cancellation of ctx (e.g. the deadline of the whole command) to err, which may be
+// any error of an aborted request.
+func contextError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(err, cause) {
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+	return err
+}
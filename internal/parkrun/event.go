@@ -1,9 +1,12 @@
 package parkrun
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/flopp/go-parkrunparser"
 )
@@ -15,37 +18,73 @@ type Event struct {
 	Country    string
 	IsComplete bool
 	Runs       []*Run
+	client     *Client
+	// guards the completion of the event
+	mu sync.Mutex
 }
 
-func (event Event) NumberOfRuns() int {
+func (event *Event) NumberOfRuns() int {
 	return len(event.Runs)
 }
 
-func AllEvents() ([]*Event, error) {
-	buf, _, err := DownloadAndRead("https://images.parkrun.com/events.json", "events.json")
+func (client *Client) AllEvents() ([]*Event, error) {
+	return client.AllEventsContext(context.Background())
+}
+
+func (client *Client) AllEventsContext(ctx context.Context) ([]*Event, error) {
+	if client.Store != nil {
+		records, fetchTime, err := client.Store.loadEvents()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 && client.isFresh(fetchTime, time.Now().Add(-client.Freshness.Events)) {
+			// the records are sorted by id
+			eventList := make([]*Event, 0, len(records))
+			for _, r := range records {
+				eventList = append(eventList, &Event{Id: r.Id, Name: r.Name, CountryUrl: r.CountryUrl, Country: r.Country, client: client})
+			}
+			return eventList, nil
+		}
+	}
+
+	url := "https://images.parkrun.com/events.json"
+	fileName := "events.json"
+	buf, fetchTime, err := client.DownloadAndRead(ctx, url, fileName, client.Freshness.Events)
 	if err != nil {
 		return nil, err
 	}
 
 	parsed_events, err := parkrunparser.ParseEvents([]byte(buf))
 	if err != nil {
-		return nil, err
+		return nil, client.parseError("events", url, fileName, err)
 	}
 
+	client.logger().Debug("parsed events", "url", url, "events", len(parsed_events.Events))
+
 	eventList := make([]*Event, 0)
 	for _, e := range parsed_events.Events {
-		eventList = append(eventList, &Event{e.Name, e.LongName, e.Country.Url, e.Country.Name(), false, nil})
+		eventList = append(eventList, &Event{Id: e.Name, Name: e.LongName, CountryUrl: e.Country.Url, Country: e.Country.Name(), client: client})
 	}
 
 	sort.Slice(eventList, func(i, j int) bool {
 		return eventList[i].Id < eventList[j].Id
 	})
 
+	if client.Store != nil {
+		if err := client.Store.saveEvents(eventList, fetchTime); err != nil {
+			return nil, err
+		}
+	}
+
 	return eventList, nil
 }
 
-func LookupEvent(eventId string) (*Event, error) {
-	eventList, err := AllEvents()
+func (client *Client) LookupEvent(eventId string) (*Event, error) {
+	return client.LookupEventContext(context.Background(), eventId)
+}
+
+func (client *Client) LookupEventContext(ctx context.Context, eventId string) (*Event, error) {
+	eventList, err := client.AllEventsContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +95,7 @@ func LookupEvent(eventId string) (*Event, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("cannot find event '%s'", eventId)
+	return nil, fmt.Errorf("%w: '%s'", ErrEventNotFound, eventId)
 }
 
 func (event *Event) IsJuniorParkrun() bool {
@@ -64,33 +103,81 @@ func (event *Event) IsJuniorParkrun() bool {
 }
 
 func (event *Event) Complete() error {
+	return event.CompleteContext(context.Background())
+}
+
+func (event *Event) CompleteContext(ctx context.Context) error {
+	return event.complete(ctx, event.client.Freshness.EventHistory)
+}
+
+// complete fetches the event history unless it is younger than maxAge.
+func (event *Event) complete(ctx context.Context, maxAge time.Duration) error {
+	event.mu.Lock()
+	defer event.mu.Unlock()
+
 	if event.IsComplete {
 		return nil
 	}
 
+	client := event.client
+	var record eventHistoryRecord
+	if client.Store != nil {
+		found, err := client.Store.get(bucketEventHistory, event.Id, &record)
+		if err != nil {
+			return err
+		}
+		if found && client.isFresh(record.FetchTime, time.Now().Add(-maxAge)) {
+			event.setRuns(record.Runs)
+			return nil
+		}
+	}
+
 	url := fmt.Sprintf("https://%s/%s/results/eventhistory/", event.CountryUrl, event.Id)
 	fileName := fmt.Sprintf("%s/%s/eventhistory", event.CountryUrl, event.Id)
-	buf, _, err := DownloadAndRead(url, fileName)
+	buf, fetchTime, err := client.DownloadAndRead(ctx, url, fileName, maxAge)
 	if err != nil {
 		return err
 	}
 
 	eventhistory, err := parkrunparser.ParseEventHistory([]byte(buf))
 	if err != nil {
-		return fmt.Errorf("while parsing eventhistory of %s from %s: %w", event.Id, fileName, err)
+		return event.client.parseError(fmt.Sprintf("eventhistory of %s", event.Id), url, fileName, err)
 	}
 
-	event.Runs = make([]*Run, len(eventhistory.Results))
+	event.client.logger().Debug("parsed eventhistory", "event", event.Id, "url", url, "runs", len(eventhistory.Results))
+
+	record = eventHistoryRecord{FetchTime: fetchTime, Runs: make([]runInfo, len(eventhistory.Results))}
 	for _, result := range eventhistory.Results {
-		event.Runs[result.Index-1] = CreateRun(event, uint64(result.Index), result.Date, uint64(result.NumberOfFinishers), uint64(result.NumberOfVolunteers))
+		record.Runs[result.Index-1] = runInfo{uint64(result.Index), result.Date, uint64(result.NumberOfFinishers), uint64(result.NumberOfVolunteers)}
+	}
+	if client.Store != nil {
+		if err := client.Store.put(bucketEventHistory, event.Id, &record); err != nil {
+			return err
+		}
 	}
 
-	event.IsComplete = true
+	event.setRuns(record.Runs)
 	return nil
 }
 
-func (event *Event) getNumberOfRuns() (uint64, error) {
-	err := event.Complete()
+func (event *Event) setRuns(runs []runInfo) {
+	event.Runs = make([]*Run, len(runs))
+	for i, r := range runs {
+		event.Runs[i] = CreateRun(event, r.Index, r.Time, r.NRunners, r.NVolunteers)
+	}
+	event.IsComplete = true
+}
+
+// CompleteRunsContext completes the event and all of its runs, fetching up to Client.Parallelism results pages concurrently.
+func (event *Event) CompleteRunsContext(ctx context.Context) error {
+	if err := event.CompleteContext(ctx); err != nil {
+		return err
+	}
+	return event.client.completeRuns(ctx, event.Runs)
+}
+
+func (event *Event) getNumberOfRuns(ctx context.Context) (uint64, error) {
+	err := event.CompleteContext(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -98,13 +185,13 @@ func (event *Event) getNumberOfRuns() (uint64, error) {
 	return uint64(len(event.Runs)), nil
 }
 
-func (event *Event) getParkrunnersFromRun(runIndex uint64, parkrunners map[string]*Parkrunner) (map[string]*Parkrunner, error) {
+func (event *Event) getParkrunnersFromRun(ctx context.Context, runIndex uint64, parkrunners map[string]*Parkrunner) (map[string]*Parkrunner, error) {
 	if runIndex < 1 || runIndex > uint64(len(event.Runs)) {
-		return parkrunners, fmt.Errorf("%s: bad run #%d", event.Id, runIndex)
+		return parkrunners, fmt.Errorf("%w: %s #%d", ErrBadRunIndex, event.Id, runIndex)
 	}
 
 	run := event.Runs[runIndex-1]
-	err := run.Complete()
+	err := run.CompleteContext(ctx)
 	if err != nil {
 		return parkrunners, err
 	}
@@ -127,7 +214,11 @@ func (event *Event) getParkrunnersFromRun(runIndex uint64, parkrunners map[strin
 }
 
 func (event *Event) GetActiveParkrunners(minActiveRatio float64, examineNumberOfRuns uint64) ([]*Parkrunner, uint64, error) {
-	numberOfRuns, err := event.getNumberOfRuns()
+	return event.GetActiveParkrunnersContext(context.Background(), minActiveRatio, examineNumberOfRuns)
+}
+
+func (event *Event) GetActiveParkrunnersContext(ctx context.Context, minActiveRatio float64, examineNumberOfRuns uint64) ([]*Parkrunner, uint64, error) {
+	numberOfRuns, err := event.getNumberOfRuns(ctx)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -143,37 +234,33 @@ func (event *Event) GetActiveParkrunners(minActiveRatio float64, examineNumberOf
 
 	parkrunners := make(map[string]*Parkrunner)
 
-	fmt.Printf("-- Fetching the latest %d result lists...\n", 1+toIndex-fromIndex)
+	if err := event.client.completeRuns(ctx, event.Runs[fromIndex-1:toIndex]); err != nil {
+		return nil, 0, err
+	}
+	// merge in run order, such that the result does not depend on the order of the downloads
 	for index := fromIndex; index <= toIndex; index += 1 {
-		if parkrunners, err = event.getParkrunnersFromRun(index, parkrunners); err != nil {
+		if parkrunners, err = event.getParkrunnersFromRun(ctx, index, parkrunners); err != nil {
 			return nil, 0, err
 		}
 	}
 
 	activeLimit := int64(minActiveRatio * (float64(1 + toIndex - fromIndex)))
 	lastRunDate := event.Runs[len(event.Runs)-1].Time
-	updatesNeeded := 0
-	for _, parkrunner := range parkrunners {
-		if len(parkrunner.Active) >= int(activeLimit) {
-			if parkrunner.NeedsUpdate() {
-				updatesNeeded += 1
-			}
-		}
-	}
-	fmt.Printf("-- Updating %d incomplete parkrunners...\n", updatesNeeded)
-
 	activeParkrunners := make([]*Parkrunner, 0)
 	for _, parkrunner := range parkrunners {
 		if len(parkrunner.Active) >= int(activeLimit) {
-			if err = parkrunner.FetchMissingStats(lastRunDate); err != nil {
-				return nil, 0, err
-			}
 			activeParkrunners = append(activeParkrunners, parkrunner)
 		}
 	}
+	if err := event.client.FetchAllMissingStats(ctx, activeParkrunners, lastRunDate); err != nil {
+		return nil, 0, err
+	}
 
 	sort.Slice(activeParkrunners, func(i, j int) bool {
-		return activeParkrunners[i].Name < activeParkrunners[j].Name
+		if activeParkrunners[i].Name != activeParkrunners[j].Name {
+			return activeParkrunners[i].Name < activeParkrunners[j].Name
+		}
+		return activeParkrunners[i].Id < activeParkrunners[j].Id
 	})
 	return activeParkrunners, (1 + toIndex - fromIndex), nil
 }
@@ -215,15 +302,19 @@ type EventStats struct {
 	V700       []*Participant
 }
 
-func (event *Event) GetStats() *EventStats {
+func (event *Event) GetStats() (*EventStats, error) {
+	return event.GetStatsContext(context.Background())
+}
+
+// GetStatsContext returns the statistics of the latest run of the event, or nil if the event did not take place yet.
+func (event *Event) GetStatsContext(ctx context.Context) (*EventStats, error) {
 	if len(event.Runs) == 0 {
-		fmt.Printf("No runs at %s\n", event.Name)
-		return nil
+		return nil, nil
 	}
 
 	run := event.Runs[len(event.Runs)-1]
-	if err := run.Complete(); err != nil {
-		panic(err)
+	if err := run.CompleteContext(ctx); err != nil {
+		return nil, err
 	}
 
 	stats := EventStats{}
@@ -271,11 +362,16 @@ func (event *Event) GetStats() *EventStats {
 		}
 	}
 
-	for _, participant := range run.Volunteers {
-		parkrunner := &Parkrunner{participant.Id, participant.Name, "??", run.Time, -1, -1, -1, nil}
-		if err := parkrunner.FetchMissingStats(run.Time); err != nil {
-			panic(err)
-		}
+	volunteers := make([]*Parkrunner, len(run.Volunteers))
+	for i, participant := range run.Volunteers {
+		volunteers[i] = &Parkrunner{participant.Id, participant.Name, "??", run.Time, -1, -1, -1, nil}
+	}
+	if err := event.client.FetchAllMissingStats(ctx, volunteers, run.Time); err != nil {
+		return nil, err
+	}
+
+	for i, participant := range run.Volunteers {
+		parkrunner := volunteers[i]
 		switch parkrunner.Vols {
 		case 1:
 			stats.V1 = append(stats.V1, participant)
@@ -312,5 +408,5 @@ func (event *Event) GetStats() *EventStats {
 		}
 	}
 
-	return &stats
+	return &stats, nil
 }
@@ -0,0 +1,49 @@
+package parkrun
+
+import "time"
+
+// FreshnessPolicy determines how long the cached copies of the different kinds of parkrun pages are used.
+type FreshnessPolicy struct {
+	Events       time.Duration
+	EventHistory time.Duration
+	// results pages of runs that are younger than ResultsGracePeriod (they may still be corrected)
+	RecentResults time.Duration
+	// results pages fetched later than ResultsGracePeriod after the run are considered immutable
+	ResultsGracePeriod time.Duration
+	// profile pages are refreshed if they have been fetched earlier than ProfileDelay after the last known run
+	ProfileDelay time.Duration
+	// profile pages without a known last run
+	Profiles time.Duration
+}
+
+var DefaultFreshness = FreshnessPolicy{
+	Events:             24 * time.Hour,
+	EventHistory:       24 * time.Hour,
+	RecentResults:      24 * time.Hour,
+	ResultsGracePeriod: 7 * 24 * time.Hour,
+	ProfileDelay:       24 * time.Hour,
+	Profiles:           24 * time.Hour,
+}
+
+// Forced returns a copy of the policy that refreshes all pages that may have changed;
+// immutable results pages are still taken from the cache.
+func (policy FreshnessPolicy) Forced() FreshnessPolicy {
+	policy.Events = 0
+	policy.EventHistory = 0
+	policy.RecentResults = 0
+	policy.Profiles = 0
+	return policy
+}
+
+// resultsMaxMtime returns the time after which a cached results page of a run on runTime must have been fetched to be valid.
+func (policy FreshnessPolicy) resultsMaxMtime(runTime time.Time, now time.Time) time.Time {
+	immutableFrom := runTime.Add(policy.ResultsGracePeriod)
+	if immutableFrom.Before(now) {
+		return immutableFrom
+	}
+	return now.Add(-policy.RecentResults)
+}
+
+func (policy FreshnessPolicy) profileMaxMtime(lastRunTime time.Time) time.Time {
+	return lastRunTime.Add(policy.ProfileDelay)
+}
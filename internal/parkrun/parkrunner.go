@@ -1,6 +1,7 @@
 package parkrun
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -133,12 +134,12 @@ func ExtractData(buf string) (string, string, int, int, int, error) {
 	return name, id, r, j, v, nil
 }
 
-func (parkrunner *Parkrunner) NeedsUpdate() bool {
+func (parkrunner *Parkrunner) NeedsUpdate(maxAge time.Duration) bool {
 	// always update old data
 	if parkrunner.Id == "" {
 		return false
 	}
-	if parkrunner.DataTime.Add(MaxFileAge).Before(time.Now()) {
+	if parkrunner.DataTime.Add(maxAge).Before(time.Now()) {
 		return true
 	}
 	if parkrunner.Runs >= 0 || parkrunner.JuniorRuns >= 0 || parkrunner.Vols >= 0 {
@@ -165,57 +166,53 @@ func (parkrunner *Parkrunner) update(dataTime time.Time, ageGroup string, runs i
 	}
 }
 
-func (parkrunner *Parkrunner) FetchMissingStats(lastRunTime time.Time) error {
-	if !parkrunner.NeedsUpdate() {
-		return nil
-	}
+// ProfileUrl returns the url of the profile page of the parkrunner with the given ID.
+func (client *Client) ProfileUrl(id string) string {
+	return fmt.Sprintf("https://%s/parkrunner/%s/", client.ProfileHost, id)
+}
 
-	url := fmt.Sprintf("https://www.parkrun.org.uk/parkrunner/%s/", parkrunner.Id)
-	fileName := fmt.Sprintf("parkrunner/%s", parkrunner.Id)
-	buf, dataTime, err := DownloadAndReadMaxMtime(url, fileName, lastRunTime.Add(24*time.Hour))
-	if err != nil {
-		return err
+// ProfileFileName returns the cache file name of the profile page of the parkrunner with the given ID.
+func ProfileFileName(id string) string {
+	return fmt.Sprintf("parkrunner/%s", id)
+}
+
+func (client *Client) FetchMissingStats(parkrunner *Parkrunner, lastRunTime time.Time) error {
+	return client.FetchMissingStatsContext(context.Background(), parkrunner, lastRunTime)
+}
+
+func (client *Client) FetchMissingStatsContext(ctx context.Context, parkrunner *Parkrunner, lastRunTime time.Time) error {
+	if !parkrunner.NeedsUpdate(client.Freshness.Profiles) {
+		return nil
 	}
 
-	name, id, r, j, v, err := ExtractData(string(buf))
+	profile, err := client.profile(ctx, parkrunner.Id, client.Freshness.profileMaxMtime(lastRunTime))
 	if err != nil {
 		return err
 	}
 
-	if parkrunner.Id != id {
-		return fmt.Errorf("ID mismatch: expected %s, got %s", parkrunner.Id, id)
-	}
-
 	// only update name if it is not set yet
 	if parkrunner.Name == "" {
-		parkrunner.Name = name
+		parkrunner.Name = profile.Name
 	}
 
-	parkrunner.update(dataTime, "??", int64(r), int64(j), int64(v))
-
+	parkrunner.update(profile.DataTime, "??", profile.Runs, profile.JuniorRuns, profile.Vols)
 	return nil
 }
 
-var reParkrunnerEvent = regexp.MustCompile(`<tr><td><a href=".*/(.*)/results">[^<]*</a></td><td>(\d+)</td><td>[^<]*</td><td>[^<]*</td><td><span class="pretty-time">[^<]*</span></td><td><a href="[^"]*"`)
+func (client *Client) GetParkrunnerCountry(id string, eventCountries map[string]string) (string, error) {
+	return client.GetParkrunnerCountryContext(context.Background(), id, eventCountries)
+}
 
-func GetParkrunnerCountry(id string, eventCountries map[string]string) (string, error) {
-	url := fmt.Sprintf("https://www.parkrun.org.uk/parkrunner/%s/", id)
-	fileName := fmt.Sprintf("parkrunner/%s", id)
-	buf, _, err := DownloadAndRead(url, fileName)
+func (client *Client) GetParkrunnerCountryContext(ctx context.Context, id string, eventCountries map[string]string) (string, error) {
+	profile, err := client.ParkrunnerProfileContext(ctx, id)
 	if err != nil {
 		return "", err
 	}
 
 	counts := make(map[string]int)
-	for _, match := range reParkrunnerEvent.FindAllStringSubmatch(string(buf), -1) {
-		eventId := match[1]
-		count, err := strconv.Atoi(match[2])
-		if err != nil {
-			panic(err)
-		}
-		if country, found := eventCountries[eventId]; found {
-			//fmt.Printf("%s => %s %d %s\n", id, eventId, count, country)
-			counts[country] += count
+	for _, event := range profile.Events {
+		if country, found := eventCountries[event.EventId]; found {
+			counts[country] += int(event.Runs)
 		}
 	}
 
@@ -228,5 +225,6 @@ func GetParkrunnerCountry(id string, eventCountries map[string]string) (string,
 		}
 	}
 
+	client.logger().Debug("parkrunner country", "id", id, "country", maxCountry, "runs", maxCount)
 	return maxCountry, nil
 }
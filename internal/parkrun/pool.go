@@ -0,0 +1,100 @@
+package parkrun
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// forEach calls f for all 0 <= i < n using at most client.Parallelism workers; it stops at the first error and returns it.
+// The calls are reported to the client's Observer as a batch with the given name.
+func (client *Client) forEach(ctx context.Context, name string, n int, f func(ctx context.Context, i int) error) error {
+	b := &batch{name: name, total: n}
+	ctx = withBatch(ctx, b)
+	client.notify(ctx, BatchStarted, "", nil)
+	defer client.notify(ctx, BatchFinished, "", nil)
+
+	workers := client.Parallelism
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var once sync.Once
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := f(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				b.done.Add(1)
+				client.notify(ctx, BatchProgress, "", nil)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i += 1 {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return contextError(ctx, ctx.Err())
+}
+
+// completeRuns completes the given runs concurrently.
+func (client *Client) completeRuns(ctx context.Context, runs []*Run) error {
+	err := client.forEach(ctx, "result lists", len(runs), func(ctx context.Context, i int) error {
+		return runs[i].CompleteContext(ctx)
+	})
+	return client.flushStore(err)
+}
+
+// FetchAllMissingStats calls FetchMissingStatsContext for all parkrunners concurrently.
+func (client *Client) FetchAllMissingStats(ctx context.Context, parkrunners []*Parkrunner, lastRunTime time.Time) error {
+	err := client.forEach(ctx, "parkrunner profiles", len(parkrunners), func(ctx context.Context, i int) error {
+		return client.FetchMissingStatsContext(ctx, parkrunners[i], lastRunTime)
+	})
+	return client.flushStore(err)
+}
+
+// FetchProfiles fetches the profiles of the parkrunners with the given IDs concurrently, unless they are stored and
+// younger than Freshness.Profiles.
+func (client *Client) FetchProfiles(ctx context.Context, ids []string) error {
+	err := client.forEach(ctx, "parkrunner profiles", len(ids), func(ctx context.Context, i int) error {
+		_, err := client.ParkrunnerProfileContext(ctx, ids[i])
+		return err
+	})
+	return client.flushStore(err)
+}
+
+// flushStore writes the records of a batch to the store, also those completed before err.
+func (client *Client) flushStore(err error) error {
+	if client.Store == nil {
+		return err
+	}
+	if flushErr := client.Store.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
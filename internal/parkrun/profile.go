@@ -0,0 +1,382 @@
+package parkrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParkrunnerProfile holds everything shown on the profile page of a parkrunner.
+type ParkrunnerProfile struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	DataTime    time.Time `json:"data_time"`
+	Runs        int64     `json:"runs"`
+	JuniorRuns  int64     `json:"junior_runs"`
+	Vols        int64     `json:"vols"`
+	AgeCategory string    `json:"age_category"`
+	Club        string    `json:"club"`
+	// per-event summary, in the order of the page
+	Events []ProfileEvent `json:"events"`
+	// latest results, newest first
+	RecentResults  []ProfileResult `json:"recent_results"`
+	VolunteerRoles []VolunteerRole `json:"volunteer_roles"`
+	tableErrors    []error
+}
+
+type ProfileEvent struct {
+	EventId   string `json:"event_id"`
+	EventName string `json:"event_name"`
+	Runs      int64  `json:"runs"`
+	// 0 if unknown
+	BestGenderPosition int64 `json:"best_gender_position"`
+	BestPosition       int64 `json:"best_position"`
+	// encoded as best_time_seconds
+	BestTime time.Duration `json:"-"`
+}
+
+type ProfileResult struct {
+	EventId   string    `json:"event_id"`
+	EventName string    `json:"event_name"`
+	Date      time.Time `json:"date"`
+	Run       uint64    `json:"run"`
+	Position  int64     `json:"position"`
+	// encoded as time_seconds
+	Time time.Duration `json:"-"`
+	// in percent
+	AgeGrade float64 `json:"age_grade"`
+	PB       bool    `json:"pb"`
+}
+
+// the JSON encodings of ProfileEvent and ProfileResult, with the durations in seconds
+
+type profileEvent ProfileEvent
+
+type profileEventJSON struct {
+	profileEvent
+	BestTimeSeconds int64 `json:"best_time_seconds"`
+}
+
+func (event ProfileEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(profileEventJSON{profileEvent(event), int64(event.BestTime / time.Second)})
+}
+
+func (event *ProfileEvent) UnmarshalJSON(buf []byte) error {
+	var v profileEventJSON
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	*event = ProfileEvent(v.profileEvent)
+	event.BestTime = time.Duration(v.BestTimeSeconds) * time.Second
+	return nil
+}
+
+type profileResult ProfileResult
+
+type profileResultJSON struct {
+	profileResult
+	TimeSeconds int64 `json:"time_seconds"`
+}
+
+func (result ProfileResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(profileResultJSON{profileResult(result), int64(result.Time / time.Second)})
+}
+
+func (result *ProfileResult) UnmarshalJSON(buf []byte) error {
+	var v profileResultJSON
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	*result = ProfileResult(v.profileResult)
+	result.Time = time.Duration(v.TimeSeconds) * time.Second
+	return nil
+}
+
+type VolunteerRole struct {
+	Role      string `json:"role"`
+	Occasions int64  `json:"occasions"`
+}
+
+var (
+	patternAgeCategory = regexp.MustCompile(`Most recent age category was\s*([^<\s]+)`)
+	patternClub        = regexp.MustCompile(`<a href="[^"]*/clubs?/[^"]*"[^>]*>([^<]+)</a>`)
+	patternTable       = regexp.MustCompile(`(?s)<table[^>]*>(.*?)</table>`)
+	patternRow         = regexp.MustCompile(`(?s)<tr[^>]*>(.*?)</tr>`)
+	patternCell        = regexp.MustCompile(`(?s)<t([hd])[^>]*>(.*?)</t[hd]>`)
+	patternHref        = regexp.MustCompile(`href="([^"]*)"`)
+	patternTag         = regexp.MustCompile(`<[^>]*>`)
+	patternResultsHref = regexp.MustCompile(`/([^/]+)/results`)
+)
+
+type htmlCell struct {
+	text string
+	href string
+}
+
+type htmlTable struct {
+	header []string
+	rows   [][]htmlCell
+}
+
+// column returns the index of the column whose header is name (ignoring case), or -1.
+func (t *htmlTable) column(name string) int {
+	for i, h := range t.header {
+		if strings.EqualFold(h, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *htmlTable) hasColumns(names ...string) bool {
+	for _, name := range names {
+		if t.column(name) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func extractTables(buf string) []*htmlTable {
+	tables := make([]*htmlTable, 0)
+	for _, tableMatch := range patternTable.FindAllStringSubmatch(buf, -1) {
+		t := &htmlTable{}
+		for _, rowMatch := range patternRow.FindAllStringSubmatch(tableMatch[1], -1) {
+			row := make([]htmlCell, 0)
+			isHeader := false
+			for _, cellMatch := range patternCell.FindAllStringSubmatch(rowMatch[1], -1) {
+				isHeader = cellMatch[1] == "h"
+				cell := htmlCell{text: strings.TrimSpace(html.UnescapeString(patternTag.ReplaceAllString(cellMatch[2], "")))}
+				if href := patternHref.FindStringSubmatch(cellMatch[2]); href != nil {
+					cell.href = html.UnescapeString(href[1])
+				}
+				row = append(row, cell)
+			}
+			if isHeader && t.header == nil {
+				for _, cell := range row {
+					t.header = append(t.header, cell.text)
+				}
+			} else if len(row) > 0 {
+				t.rows = append(t.rows, row)
+			}
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+func (t *htmlTable) cell(row []htmlCell, name string) htmlCell {
+	if i := t.column(name); i >= 0 && i < len(row) {
+		return row[i]
+	}
+	return htmlCell{}
+}
+
+func eventIdFromHref(href string) string {
+	if match := patternResultsHref.FindStringSubmatch(href); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// parseInt parses s, where an empty string or a placeholder means 0.
+func parseInt(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// parseDuration parses MM:SS or H:MM:SS.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" {
+		return 0, nil
+	}
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("bad time '%s'", s)
+		}
+		d = 60*d + time.Duration(n)*time.Second
+	}
+	return d, nil
+}
+
+// tableParser collects the problems of the profile tables, which do not make the whole profile unusable.
+type tableParser struct {
+	errors []error
+}
+
+func (p *tableParser) fail(table string, row int, err error) {
+	p.errors = append(p.errors, fmt.Errorf("%s, row %d: %w", table, row+1, err))
+}
+
+func (p *tableParser) int(table string, row int, s string) int64 {
+	n, err := parseInt(s)
+	if err != nil {
+		p.fail(table, row, err)
+	}
+	return n
+}
+
+func (p *tableParser) duration(table string, row int, s string) time.Duration {
+	d, err := parseDuration(s)
+	if err != nil {
+		p.fail(table, row, err)
+	}
+	return d
+}
+
+func (p *tableParser) events(t *htmlTable) []ProfileEvent {
+	const table = "event summary"
+	events := make([]ProfileEvent, 0, len(t.rows))
+	for i, row := range t.rows {
+		event := t.cell(row, "Event")
+		events = append(events, ProfileEvent{
+			EventId:            eventIdFromHref(event.href),
+			EventName:          event.text,
+			Runs:               p.int(table, i, t.cell(row, "Runs").text),
+			BestGenderPosition: p.int(table, i, t.cell(row, "Best Gender Position").text),
+			BestPosition:       p.int(table, i, t.cell(row, "Best Position Overall").text),
+			BestTime:           p.duration(table, i, t.cell(row, "Best Time").text),
+		})
+	}
+	return events
+}
+
+func (p *tableParser) recentResults(t *htmlTable) []ProfileResult {
+	const table = "recent results"
+	results := make([]ProfileResult, 0, len(t.rows))
+	for i, row := range t.rows {
+		event := t.cell(row, "Event")
+		r := ProfileResult{
+			EventId:   eventIdFromHref(event.href),
+			EventName: event.text,
+			Run:       uint64(p.int(table, i, t.cell(row, "Run Number").text)),
+			Position:  p.int(table, i, t.cell(row, "Pos").text),
+			Time:      p.duration(table, i, t.cell(row, "Time").text),
+			PB:        strings.Contains(strings.ToUpper(t.cell(row, "PB?").text), "PB"),
+		}
+		date, err := time.Parse("02/01/2006", t.cell(row, "Run Date").text)
+		if err != nil {
+			p.fail(table, i, err)
+		}
+		r.Date = date
+		if ageGrade := strings.TrimSpace(strings.TrimSuffix(t.cell(row, "Age Grade").text, "%")); ageGrade != "" {
+			if r.AgeGrade, err = strconv.ParseFloat(ageGrade, 64); err != nil {
+				p.fail(table, i, err)
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func (p *tableParser) volunteerRoles(t *htmlTable) []VolunteerRole {
+	const table = "volunteer summary"
+	roles := make([]VolunteerRole, 0, len(t.rows))
+	for i, row := range t.rows {
+		role := t.cell(row, "Role").text
+		// the last row holds the total credits
+		if role == "" || strings.EqualFold(role, "Total Credits") {
+			continue
+		}
+		roles = append(roles, VolunteerRole{role, p.int(table, i, t.cell(row, "Occasions").text)})
+	}
+	return roles
+}
+
+// ParseParkrunnerProfile parses the profile page of a parkrunner. Only missing name, ID or totals are errors; cells
+// of the tables that cannot be parsed are left empty and reported by TableErrors.
+func ParseParkrunnerProfile(buf string) (*ParkrunnerProfile, error) {
+	name, id, r, j, v, err := ExtractData(buf)
+	if err != nil {
+		return nil, err
+	}
+	profile := &ParkrunnerProfile{Id: id, Name: name, Runs: int64(r), JuniorRuns: int64(j), Vols: int64(v)}
+	if match := patternAgeCategory.FindStringSubmatch(buf); match != nil {
+		profile.AgeCategory = match[1]
+	}
+	if match := patternClub.FindStringSubmatch(buf); match != nil {
+		profile.Club = strings.TrimSpace(html.UnescapeString(match[1]))
+	}
+
+	p := &tableParser{}
+	for _, t := range extractTables(buf) {
+		switch {
+		case t.hasColumns("Event", "Runs", "Best Time"):
+			profile.Events = p.events(t)
+		case t.hasColumns("Event", "Run Date", "Run Number"):
+			profile.RecentResults = p.recentResults(t)
+		case t.hasColumns("Role", "Occasions"):
+			profile.VolunteerRoles = p.volunteerRoles(t)
+		}
+	}
+	profile.tableErrors = p.errors
+	return profile, nil
+}
+
+// TableErrors returns the problems found while parsing the tables of the profile page.
+func (profile *ParkrunnerProfile) TableErrors() []error {
+	return profile.tableErrors
+}
+
+func (client *Client) ParkrunnerProfile(id string) (*ParkrunnerProfile, error) {
+	return client.ParkrunnerProfileContext(context.Background(), id)
+}
+
+// ParkrunnerProfileContext returns the profile of the parkrunner with the given ID, which is at most
+// Freshness.Profiles old.
+func (client *Client) ParkrunnerProfileContext(ctx context.Context, id string) (*ParkrunnerProfile, error) {
+	return client.profile(ctx, id, time.Now().Add(-client.Freshness.Profiles))
+}
+
+// profile returns the stored profile if it is younger than maxMtime, and fetches and parses the profile page otherwise.
+func (client *Client) profile(ctx context.Context, id string, maxMtime time.Time) (*ParkrunnerProfile, error) {
+	if client.Store != nil {
+		var profile ParkrunnerProfile
+		found, err := client.Store.get(bucketParkrunners, id, &profile)
+		if err != nil {
+			return nil, err
+		}
+		if found && client.isFresh(profile.DataTime, maxMtime) {
+			return &profile, nil
+		}
+	}
+
+	url := client.ProfileUrl(id)
+	fileName := ProfileFileName(id)
+	buf, dataTime, err := client.DownloadAndReadMaxMtime(ctx, url, fileName, maxMtime)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := ParseParkrunnerProfile(string(buf))
+	if err != nil {
+		return nil, client.parseError(fmt.Sprintf("profile of %s", id), url, fileName, err)
+	}
+	if profile.Id != id {
+		return nil, client.parseError(fmt.Sprintf("profile of %s", id), url, fileName, fmt.Errorf("ID mismatch: got %s", profile.Id))
+	}
+	profile.DataTime = dataTime
+	for _, tableErr := range profile.tableErrors {
+		client.logger().Warn("cannot parse profile table", "id", id, "url", url, "err", tableErr)
+	}
+
+	client.logger().Debug("parsed profile", "id", id, "url", url, "runs", profile.Runs, "junior_runs", profile.JuniorRuns, "vols", profile.Vols, "events", len(profile.Events))
+
+	if client.Store != nil {
+		if err := client.Store.put(bucketParkrunners, id, profile); err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
@@ -0,0 +1,118 @@
+package parkrun
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	buf, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestParseParkrunnerProfile(t *testing.T) {
+	profile, err := ParseParkrunnerProfile(readFixture(t, "profile.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := profile.TableErrors(); len(errs) != 0 {
+		t.Errorf("unexpected table errors: %v", errs)
+	}
+
+	if profile.Id != "1234567" || profile.Name != "Jane DOE" {
+		t.Errorf("got id '%s', name '%s'", profile.Id, profile.Name)
+	}
+	if profile.Runs != 123 || profile.JuniorRuns != 4 || profile.Vols != 12 {
+		t.Errorf("got runs %d, junior runs %d, vols %d", profile.Runs, profile.JuniorRuns, profile.Vols)
+	}
+	if profile.AgeCategory != "VW45-49" || profile.Club != "Lauftreff Freiburg" {
+		t.Errorf("got age category '%s', club '%s'", profile.AgeCategory, profile.Club)
+	}
+
+	if len(profile.Events) != 2 {
+		t.Fatalf("got %d events, expected 2", len(profile.Events))
+	}
+	bushy := profile.Events[1]
+	if bushy.EventId != "bushy" || bushy.Runs != 23 || bushy.BestGenderPosition != 0 || bushy.BestPosition != 412 || bushy.BestTime != time.Hour+2*time.Minute+3*time.Second {
+		t.Errorf("bad event: %+v", bushy)
+	}
+
+	if len(profile.RecentResults) != 2 {
+		t.Fatalf("got %d results, expected 2", len(profile.RecentResults))
+	}
+	latest := profile.RecentResults[0]
+	if latest.EventId != "dietenbach" || latest.Run != 250 || latest.Position != 15 || latest.Time != 22*time.Minute+30*time.Second || latest.AgeGrade != 65.33 || !latest.PB {
+		t.Errorf("bad result: %+v", latest)
+	}
+	if !latest.Date.Equal(time.Date(2024, 10, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("bad date: %s", latest.Date)
+	}
+	if profile.RecentResults[1].PB {
+		t.Errorf("unexpected PB: %+v", profile.RecentResults[1])
+	}
+
+	if len(profile.VolunteerRoles) != 2 || profile.VolunteerRoles[1] != (VolunteerRole{"Run Director", 7}) {
+		t.Errorf("bad volunteer roles: %+v", profile.VolunteerRoles)
+	}
+}
+
+func TestParseParkrunnerProfileBadTable(t *testing.T) {
+	buf := strings.Replace(readFixture(t, "profile.html"), "<td>21:05</td>", "<td>fast</td>", 1)
+	buf = strings.Replace(buf, "12/10/2024", "yesterday", 1)
+	profile, err := ParseParkrunnerProfile(buf)
+	if err != nil {
+		t.Fatalf("table errors must not be fatal: %v", err)
+	}
+	if errs := profile.TableErrors(); len(errs) != 2 {
+		t.Errorf("got table errors %v, expected 2", errs)
+	}
+	// the totals and the other cells are kept
+	if profile.Runs != 123 || profile.Vols != 12 {
+		t.Errorf("got runs %d, vols %d", profile.Runs, profile.Vols)
+	}
+	if len(profile.Events) != 2 || profile.Events[0].BestTime != 0 || profile.Events[0].Runs != 100 {
+		t.Errorf("bad events: %+v", profile.Events)
+	}
+	if len(profile.RecentResults) != 2 || !profile.RecentResults[0].Date.IsZero() || profile.RecentResults[0].Run != 250 {
+		t.Errorf("bad results: %+v", profile.RecentResults)
+	}
+}
+
+func TestParseParkrunnerProfileMissingHeader(t *testing.T) {
+	buf := strings.Replace(readFixture(t, "profile.html"), "(A1234567)", "", 1)
+	if _, err := ParseParkrunnerProfile(buf); err == nil {
+		t.Error("expected an error for a profile without ID")
+	}
+}
+
+func TestParkrunnerProfileJSON(t *testing.T) {
+	profile, err := ParseParkrunnerProfile(readFixture(t, "profile.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"best_time_seconds":3723`, `"time_seconds":1350`} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("expected %s in %s", expected, buf)
+		}
+	}
+
+	var decoded ParkrunnerProfile
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Events, profile.Events) || !reflect.DeepEqual(decoded.RecentResults, profile.RecentResults) {
+		t.Errorf("got %+v and %+v, expected %+v and %+v", decoded.Events, decoded.RecentResults, profile.Events, profile.RecentResults)
+	}
+}
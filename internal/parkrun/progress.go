@@ -0,0 +1,118 @@
+package parkrun
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type ProgressKind int
+
+const (
+	// a batch of Total items (e.g. the result lists of an event) is about to be processed
+	BatchStarted ProgressKind = iota
+	// one more item of the batch is done (Done of Total)
+	BatchProgress
+	BatchFinished
+	FetchStarted
+	FetchFinished
+	FetchFailed
+	// Url is served from the cache without a request
+	CacheHit
+)
+
+func (kind ProgressKind) String() string {
+	switch kind {
+	case BatchStarted:
+		return "batch-started"
+	case BatchProgress:
+		return "batch-progress"
+	case BatchFinished:
+		return "batch-finished"
+	case FetchStarted:
+		return "fetch-started"
+	case FetchFinished:
+		return "fetch-finished"
+	case FetchFailed:
+		return "fetch-failed"
+	case CacheHit:
+		return "cache-hit"
+	}
+	return "unknown"
+}
+
+// Progress describes a single progress event.
+type Progress struct {
+	Kind ProgressKind
+	// name of the current batch, e.g. "result lists" (empty outside of batches)
+	Batch string
+	// number of finished and total items of the current batch
+	Done  int
+	Total int
+	// the url of Fetch* and CacheHit events
+	Url string
+	// the error of FetchFailed events
+	Err error
+	// counts since the creation of the client
+	Fetched   int64
+	CacheHits int64
+	Errors    int64
+}
+
+// An Observer receives the progress events of a client; it is called concurrently by all download workers.
+type Observer interface {
+	Observe(progress Progress)
+}
+
+// ObserverFunc adapts an ordinary function to the Observer interface.
+type ObserverFunc func(progress Progress)
+
+func (f ObserverFunc) Observe(progress Progress) {
+	f(progress)
+}
+
+type progressCounts struct {
+	fetched   atomic.Int64
+	cacheHits atomic.Int64
+	errors    atomic.Int64
+}
+
+type batch struct {
+	name  string
+	total int
+	done  atomic.Int64
+}
+
+type batchKey struct{}
+
+func withBatch(ctx context.Context, b *batch) context.Context {
+	return context.WithValue(ctx, batchKey{}, b)
+}
+
+func (client *Client) notify(ctx context.Context, kind ProgressKind, url string, err error) {
+	switch kind {
+	case FetchFinished:
+		client.counts.fetched.Add(1)
+	case FetchFailed:
+		client.counts.errors.Add(1)
+	case CacheHit:
+		client.counts.cacheHits.Add(1)
+	}
+	if client.Observer == nil {
+		return
+	}
+
+	progress := Progress{
+		Kind:      kind,
+		Url:       url,
+		Err:       err,
+		Fetched:   client.counts.fetched.Load(),
+		CacheHits: client.counts.cacheHits.Load(),
+		Errors:    client.counts.errors.Load(),
+	}
+	if b, ok := ctx.Value(batchKey{}).(*batch); ok {
+		progress.Batch = b.name
+		progress.Done = int(b.done.Load())
+		progress.Total = b.total
+	}
+	client.Observer.Observe(progress)
+}
@@ -1,7 +1,9 @@
 package parkrun
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/flopp/go-parkrunparser"
@@ -28,30 +30,58 @@ type Run struct {
 	NVolunteers uint64
 	Runners     []*Participant
 	Volunteers  []*Participant
+	// guards the completion of the run
+	mu sync.Mutex
 }
 
 func CreateRun(parent *Event, index uint64, t time.Time, nFinishers, nVolunteers uint64) *Run {
-	return &Run{parent, index, t, false, time.Time{}, nFinishers, nVolunteers, nil, nil}
+	return &Run{Parent: parent, Index: index, Time: t, NRunners: nFinishers, NVolunteers: nVolunteers}
 }
 
 func (run *Run) Complete() error {
+	return run.CompleteContext(context.Background())
+}
+
+func (run *Run) CompleteContext(ctx context.Context) error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
 	if run.IsComplete {
 		return nil
 	}
 
 	event := run.Parent
+	client := event.client
+	maxMtime := client.Freshness.resultsMaxMtime(run.Time, time.Now())
+	if client.Store != nil {
+		var record runRecord
+		found, err := client.Store.get(bucketRuns, runKey(event.Id, run.Index), &record)
+		if err != nil {
+			return err
+		}
+		if found && client.isFresh(record.DataTime, maxMtime) {
+			run.IsComplete = true
+			run.DataTime = record.DataTime
+			run.Runners = record.Runners
+			run.Volunteers = record.Volunteers
+			return nil
+		}
+	}
+
 	url := fmt.Sprintf("https://%s/%s/results/%d/", event.CountryUrl, event.Id, run.Index)
 	fileName := fmt.Sprintf("%s/%s/%d", event.CountryUrl, event.Id, run.Index)
-	buf, dataTime, err := DownloadAndRead(url, fileName)
+	buf, dataTime, err := client.DownloadAndReadMaxMtime(ctx, url, fileName, maxMtime)
 	if err != nil {
 		return err
 	}
 
 	results, err := parkrunparser.ParseResults([]byte(buf))
 	if err != nil {
-		return fmt.Errorf("while parsing results for %s from %s: %w", event.Id, fileName, err)
+		return client.parseError(fmt.Sprintf("results of %s #%d", event.Id, run.Index), url, fileName, err)
 	}
 
+	client.logger().Debug("parsed results", "event", event.Id, "run", run.Index, "url", url, "finishers", len(results.Finishers), "volunteers", len(results.Volunteers))
+
 	run.IsComplete = true
 	run.DataTime = dataTime
 	for _, finisher := range results.Finishers {
@@ -79,5 +109,8 @@ func (run *Run) Complete() error {
 		run.Volunteers = append(run.Volunteers, &Participant{volunteer.Id, volunteer.Name, "??", parkrunparser.SEX_UNKNOWN, -1, -1, 0, parkrunparser.AchievementNone})
 	}
 
+	if client.Store != nil {
+		return client.Store.put(bucketRuns, runKey(event.Id, run.Index), &runRecord{run.DataTime, run.Runners, run.Volunteers})
+	}
 	return nil
 }
@@ -0,0 +1,129 @@
+package parkrun
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	file "github.com/flopp/parkrun-milestones/internal/file"
+)
+
+// bump when the snapshot format changes; add a migration to migrateSnapshot if old snapshots can be converted
+const snapshotVersion = 1
+
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+type eventSnapshot struct {
+	Version    int
+	SavedAt    time.Time
+	Id         string
+	Name       string
+	CountryUrl string
+	Country    string
+	IsComplete bool
+	Runs       []runSnapshot
+}
+
+type runSnapshot struct {
+	Index       uint64
+	Time        time.Time
+	IsComplete  bool
+	DataTime    time.Time
+	NRunners    uint64
+	NVolunteers uint64
+	Runners     []*Participant
+	Volunteers  []*Participant
+}
+
+// SaveEvent writes the event with all its runs, runners and volunteers to the snapshot file filePath
+// (gzip-compressed if it ends with .gz).
+func SaveEvent(filePath string, event *Event) error {
+	buf, err := json.Marshal(newEventSnapshot(event))
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(filePath, ".gz") {
+		var out bytes.Buffer
+		gz := gzip.NewWriter(&out)
+		if _, err := gz.Write(buf); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		buf = out.Bytes()
+	}
+	if err := file.WriteFileAtomic(filePath, buf); err != nil {
+		return fmt.Errorf("while saving '%s' to '%s': %w", event.Id, filePath, err)
+	}
+	return nil
+}
+
+// LoadEvent reads an event written by SaveEvent; the event and its runs use the client for further requests.
+func (client *Client) LoadEvent(filePath string) (*Event, error) {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var r io.Reader = in
+	if strings.HasSuffix(filePath, ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("while loading '%s': %w", filePath, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var snapshot eventSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("while loading '%s': %w", filePath, err)
+	}
+	if err := migrateSnapshot(&snapshot); err != nil {
+		return nil, fmt.Errorf("while loading '%s': %w", filePath, err)
+	}
+	return snapshot.event(client), nil
+}
+
+// migrateSnapshot converts a snapshot written by an older version to snapshotVersion. Version 1 is the first
+// version, so there is nothing to convert yet: snapshots of any other version are rejected with ErrSnapshotVersion
+// and have to be written again.
+func migrateSnapshot(snapshot *eventSnapshot) error {
+	switch {
+	case snapshot.Version == snapshotVersion:
+		return nil
+	case snapshot.Version > snapshotVersion:
+		return fmt.Errorf("%w: %d (written by a newer version; expected %d)", ErrSnapshotVersion, snapshot.Version, snapshotVersion)
+	}
+	return fmt.Errorf("%w: %d (expected %d)", ErrSnapshotVersion, snapshot.Version, snapshotVersion)
+}
+
+func newEventSnapshot(event *Event) *eventSnapshot {
+	event.mu.Lock()
+	defer event.mu.Unlock()
+
+	snapshot := &eventSnapshot{snapshotVersion, time.Now(), event.Id, event.Name, event.CountryUrl, event.Country, event.IsComplete, make([]runSnapshot, 0, len(event.Runs))}
+	for _, run := range event.Runs {
+		run.mu.Lock()
+		snapshot.Runs = append(snapshot.Runs, runSnapshot{run.Index, run.Time, run.IsComplete, run.DataTime, run.NRunners, run.NVolunteers, run.Runners, run.Volunteers})
+		run.mu.Unlock()
+	}
+	return snapshot
+}
+
+func (snapshot *eventSnapshot) event(client *Client) *Event {
+	event := &Event{Id: snapshot.Id, Name: snapshot.Name, CountryUrl: snapshot.CountryUrl, Country: snapshot.Country, IsComplete: snapshot.IsComplete, client: client}
+	event.Runs = make([]*Run, 0, len(snapshot.Runs))
+	for _, r := range snapshot.Runs {
+		event.Runs = append(event.Runs, &Run{Parent: event, Index: r.Index, Time: r.Time, IsComplete: r.IsComplete, DataTime: r.DataTime, NRunners: r.NRunners, NVolunteers: r.NVolunteers, Runners: r.Runners, Volunteers: r.Volunteers})
+	}
+	return event
+}
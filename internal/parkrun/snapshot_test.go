@@ -0,0 +1,67 @@
+package parkrun
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadEvent(t *testing.T) {
+	client := newTestClient(t, nil)
+	event := &Event{Id: "bushy", Name: "Bushy parkrun", CountryUrl: "www.parkrun.org.uk", Country: "United Kingdom", IsComplete: true, client: client}
+	for i := uint64(1); i <= 2; i++ {
+		run := CreateRun(event, i, time.Date(2022, 11, int(i), 9, 0, 0, 0, time.UTC), 1, 1)
+		run.IsComplete = true
+		run.DataTime = run.Time.Add(time.Hour)
+		run.Runners = []*Participant{{Id: fmt.Sprint(i), Name: "Runner", AgeGroup: "SM30-34", Runs: 24, Time: 20*time.Minute + 3*time.Second}}
+		run.Volunteers = []*Participant{{Id: "99", Name: "Volunteer", Vols: 49}}
+		event.Runs = append(event.Runs, run)
+	}
+
+	for _, name := range []string{"bushy.json", "bushy.json.gz"} {
+		filePath := filepath.Join(t.TempDir(), name)
+		if err := SaveEvent(filePath, event); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := client.LoadEvent(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Id != event.Id || loaded.Name != event.Name || loaded.CountryUrl != event.CountryUrl || loaded.Country != event.Country || !loaded.IsComplete || loaded.client != client {
+			t.Errorf("%s: got event %+v", name, loaded)
+		}
+		if len(loaded.Runs) != len(event.Runs) {
+			t.Fatalf("%s: got %d runs, expected %d", name, len(loaded.Runs), len(event.Runs))
+		}
+		for i, run := range loaded.Runs {
+			expected := event.Runs[i]
+			if run.Parent != loaded {
+				t.Errorf("%s: run #%d does not point to the loaded event", name, run.Index)
+			}
+			if run.Index != expected.Index || !run.Time.Equal(expected.Time) || !run.DataTime.Equal(expected.DataTime) || !run.IsComplete || run.NRunners != expected.NRunners || run.NVolunteers != expected.NVolunteers {
+				t.Errorf("%s: got run %+v, expected %+v", name, run, expected)
+			}
+			if !reflect.DeepEqual(run.Runners, expected.Runners) || !reflect.DeepEqual(run.Volunteers, expected.Volunteers) {
+				t.Errorf("%s: run #%d: got participants %v/%v, expected %v/%v", name, run.Index, run.Runners, run.Volunteers, expected.Runners, expected.Volunteers)
+			}
+		}
+	}
+}
+
+func TestLoadEventRejectsOtherVersions(t *testing.T) {
+	client := newTestClient(t, nil)
+	for _, version := range []int{0, snapshotVersion + 1} {
+		filePath := filepath.Join(t.TempDir(), "event.json")
+		if err := os.WriteFile(filePath, []byte(fmt.Sprintf(`{"Version":%d,"Id":"bushy"}`, version)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.LoadEvent(filePath); !errors.Is(err, ErrSnapshotVersion) {
+			t.Errorf("version %d: got error %v, expected %v", version, err, ErrSnapshotVersion)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package parkrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoreFileName is the name of the store in the cache dir.
+const StoreFileName = "parkrun.db"
+
+// bump when the records change, and add the changed buckets to storeMigrations; stores of unknown versions are
+// rebuilt from scratch
+const storeVersion = 3
+
+var (
+	bucketMeta         = []byte("meta")
+	bucketEvents       = []byte("events")
+	bucketEventHistory = []byte("eventhistory")
+	bucketRuns         = []byte("runs")
+	bucketParkrunners  = []byte("parkrunners")
+	bucketSync         = []byte("sync")
+	storeBuckets       = [][]byte{bucketMeta, bucketEvents, bucketEventHistory, bucketRuns, bucketParkrunners, bucketSync}
+
+	// the buckets cleared when migrating to a version
+	storeMigrations = map[int][][]byte{
+		2: {bucketParkrunners},
+		// profile durations in seconds
+		3: {bucketParkrunners},
+	}
+
+	keyVersion       = []byte("version")
+	keyEventsFetched = []byte("events-fetched")
+)
+
+// Store is a bbolt database of the parsed events, runs and parkrunners, such that pages need not be downloaded or
+// parsed again as long as they are fresh. The database is opened by OpenStore until Close, such that other processes
+// using the same store wait for it. Records are written in batches: they are kept in memory until the next Flush (or
+// any other write), which writes them in a single transaction.
+type Store struct {
+	Path string
+	db   *bolt.DB
+	// guards pending
+	mu sync.Mutex
+	// the records written since the last flush, by bucket and key
+	pending map[string]map[string][]byte
+}
+
+// storeTimeout is the maximum time to wait for other processes using the store.
+const storeTimeout = time.Minute
+
+type eventRecord struct {
+	Id         string
+	Name       string
+	CountryUrl string
+	Country    string
+}
+
+type runInfo struct {
+	Index       uint64
+	Time        time.Time
+	NRunners    uint64
+	NVolunteers uint64
+}
+
+type eventHistoryRecord struct {
+	FetchTime time.Time
+	Runs      []runInfo
+}
+
+type runRecord struct {
+	DataTime   time.Time
+	Runners    []*Participant
+	Volunteers []*Participant
+}
+
+// OpenStore opens the store at path, creating it if necessary; the buckets of an older version whose records changed
+// are cleared. Call Close when done.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0660, &bolt.Options{Timeout: storeTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("while opening store '%s': %w", path, err)
+	}
+	store := &Store{Path: path, db: db}
+	err = store.update(func(tx *bolt.Tx) error {
+		stale := storeBuckets
+		if meta := tx.Bucket(bucketMeta); meta != nil {
+			if version, err := strconv.Atoi(string(meta.Get(keyVersion))); err == nil && version >= 1 && version <= storeVersion {
+				stale = nil
+				for v := version + 1; v <= storeVersion; v++ {
+					stale = append(stale, storeMigrations[v]...)
+				}
+			}
+		}
+		for _, name := range stale {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		// also creates buckets added without changing the records
+		for _, name := range storeBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketMeta).Put(keyVersion, []byte(strconv.Itoa(storeVersion)))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("while opening store '%s': %w", path, err)
+	}
+	return store, nil
+}
+
+// Close writes the pending records and closes the store.
+func (store *Store) Close() error {
+	err := store.Flush()
+	if closeErr := store.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Flush writes the pending records in a single transaction.
+func (store *Store) Flush() error {
+	return store.update(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (store *Store) view(f func(tx *bolt.Tx) error) error {
+	return store.db.View(f)
+}
+
+// update runs f in a write transaction, after writing the pending records.
+func (store *Store) update(f func(tx *bolt.Tx) error) error {
+	store.mu.Lock()
+	pending := store.pending
+	store.pending = nil
+	store.mu.Unlock()
+
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		for bucket, records := range pending {
+			for key, buf := range records {
+				if err := tx.Bucket([]byte(bucket)).Put([]byte(key), buf); err != nil {
+					return err
+				}
+			}
+		}
+		return f(tx)
+	})
+	if err != nil {
+		// keep the records for the next try, unless they have been replaced meanwhile
+		store.mu.Lock()
+		for bucket, records := range pending {
+			for key, buf := range records {
+				store.setPending(bucket, key, buf, false)
+			}
+		}
+		store.mu.Unlock()
+	}
+	return err
+}
+
+// setPending adds a record to the pending ones; the caller must hold mu.
+func (store *Store) setPending(bucket string, key string, buf []byte, replace bool) {
+	if store.pending == nil {
+		store.pending = make(map[string]map[string][]byte)
+	}
+	if store.pending[bucket] == nil {
+		store.pending[bucket] = make(map[string][]byte)
+	}
+	if _, found := store.pending[bucket][key]; replace || !found {
+		store.pending[bucket][key] = buf
+	}
+}
+
+// get decodes the record stored at key into v and reports whether there is one.
+func (store *Store) get(bucket []byte, key string, v any) (bool, error) {
+	store.mu.Lock()
+	buf, found := store.pending[string(bucket)][key]
+	store.mu.Unlock()
+	if found {
+		return true, json.Unmarshal(buf, v)
+	}
+
+	err := store.view(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(bucket).Get([]byte(key))
+		if buf == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(buf, v)
+	})
+	return found, err
+}
+
+// put stores v at key with the next write transaction; see Flush.
+func (store *Store) put(bucket []byte, key string, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	store.mu.Lock()
+	store.setPending(string(bucket), key, buf, true)
+	store.mu.Unlock()
+	return nil
+}
+
+func runKey(eventId string, index uint64) string {
+	// zero-padded, such that the runs of an event are sorted by index
+	return fmt.Sprintf("%s/%06d", eventId, index)
+}
+
+func (store *Store) loadEvents() ([]eventRecord, time.Time, error) {
+	var events []eventRecord
+	var fetchTime time.Time
+	err := store.view(func(tx *bolt.Tx) error {
+		if buf := tx.Bucket(bucketMeta).Get(keyEventsFetched); buf != nil {
+			if err := fetchTime.UnmarshalText(buf); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketEvents).ForEach(func(k, v []byte) error {
+			var event eventRecord
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+			return nil
+		})
+	})
+	return events, fetchTime, err
+}
+
+func (store *Store) saveEvents(events []*Event, fetchTime time.Time) error {
+	fetched, err := fetchTime.MarshalText()
+	if err != nil {
+		return err
+	}
+	return store.update(func(tx *bolt.Tx) error {
+		// replace all events, such that removed events disappear
+		if err := tx.DeleteBucket(bucketEvents); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(bucketEvents)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			buf, err := json.Marshal(eventRecord{event.Id, event.Name, event.CountryUrl, event.Country})
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(event.Id), buf); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketMeta).Put(keyEventsFetched, fetched)
+	})
+}
+
+// RemoveEvent deletes the event history, the sync state and all runs of the event from the store.
+func (store *Store) RemoveEvent(eventId string) error {
+	return store.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketEventHistory).Delete([]byte(eventId)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketSync).Delete([]byte(eventId)); err != nil {
+			return err
+		}
+		c := tx.Bucket(bucketRuns).Cursor()
+		prefix := []byte(eventId + "/")
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveRecords deletes the records parsed from the pages of the cache entries, such that the pages are parsed again
+// instead of using stale records, e.g. after the pages have been replaced or deleted.
+func (store *Store) RemoveRecords(entries []*CacheEntry) error {
+	return store.update(func(tx *bolt.Tx) error {
+		for _, entry := range entries {
+			var err error
+			switch entry.Kind {
+			case CacheEvents:
+				if err = tx.DeleteBucket(bucketEvents); err == nil {
+					_, err = tx.CreateBucket(bucketEvents)
+				}
+				if err == nil {
+					err = tx.Bucket(bucketMeta).Delete(keyEventsFetched)
+				}
+			case CacheEventHistory:
+				err = tx.Bucket(bucketEventHistory).Delete([]byte(entry.EventId))
+			case CacheResults:
+				err = tx.Bucket(bucketRuns).Delete([]byte(runKey(entry.EventId, entry.RunIndex)))
+			case CacheProfile:
+				err = tx.Bucket(bucketParkrunners).Delete([]byte(entry.ParkrunnerId))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StoreStats holds the number of records of each kind.
+type StoreStats struct {
+	Events      int `json:"events"`
+	Histories   int `json:"histories"`
+	Runs        int `json:"runs"`
+	Parkrunners int `json:"parkrunners"`
+}
+
+func (store *Store) Stats() (StoreStats, error) {
+	var stats StoreStats
+	if err := store.Flush(); err != nil {
+		return stats, err
+	}
+	err := store.view(func(tx *bolt.Tx) error {
+		stats.Events = tx.Bucket(bucketEvents).Stats().KeyN
+		stats.Histories = tx.Bucket(bucketEventHistory).Stats().KeyN
+		stats.Runs = tx.Bucket(bucketRuns).Stats().KeyN
+		stats.Parkrunners = tx.Bucket(bucketParkrunners).Stats().KeyN
+		return nil
+	})
+	return stats, err
+}
+
+// StorePath returns the path of the store in the client's cache dir.
+func (client *Client) StorePath() (string, error) {
+	return client.CachePath(StoreFileName)
+}
+
+// isFresh reports whether a stored record with the given data time may be used instead of a page fetched after maxMtime.
+func (client *Client) isFresh(dataTime time.Time, maxMtime time.Time) bool {
+	return client.Offline || dataTime.After(maxMtime)
+}
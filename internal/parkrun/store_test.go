@@ -0,0 +1,72 @@
+package parkrun
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestOpenStoreMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StoreFileName)
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put(bucketRuns, "run", runRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put(bucketParkrunners, "1234567", ParkrunnerProfile{Id: "1234567"}); err != nil {
+		t.Fatal(err)
+	}
+	// pretend the store was written before the profiles were stored
+	err = store.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(keyVersion, []byte("1"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = OpenStore(path); err != nil {
+		t.Fatal(err)
+	}
+	var run runRecord
+	if found, err := store.get(bucketRuns, "run", &run); err != nil || !found {
+		t.Errorf("runs must be kept: found %v, err %v", found, err)
+	}
+	var profile ParkrunnerProfile
+	if found, err := store.get(bucketParkrunners, "1234567", &profile); err != nil || found {
+		t.Errorf("parkrunners must be cleared: found %v, err %v", found, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreBatch(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), StoreFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.put(bucketSync, "bushy", SyncState{EventId: "bushy", LastIndex: 902}); err != nil {
+		t.Fatal(err)
+	}
+	// pending records are visible before they are written
+	if state, err := store.SyncState("bushy"); err != nil || state.LastIndex != 902 {
+		t.Errorf("got %+v (%v) before the flush", state, err)
+	}
+	if states, err := store.SyncStates(); err != nil || len(states) != 0 {
+		t.Errorf("got %+v (%v), expected nothing written before the flush", states, err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if states, err := store.SyncStates(); err != nil || len(states) != 1 || states[0].LastIndex != 902 {
+		t.Errorf("got %+v (%v) after the flush", states, err)
+	}
+}
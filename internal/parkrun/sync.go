@@ -0,0 +1,91 @@
+package parkrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNoStore = errors.New("no store")
+
+// SyncState records up to which run an event has been synced.
+type SyncState struct {
+	EventId string
+	// index of the latest run whose results are stored
+	LastIndex uint64
+	LastSync  time.Time
+}
+
+// SyncResult describes a single sync of an event.
+type SyncResult struct {
+	SyncState
+	// indices of the runs fetched by this sync
+	NewRuns []uint64
+}
+
+// SyncState returns the sync state of the event; the zero state (with EventId set) if it has never been synced.
+func (store *Store) SyncState(eventId string) (SyncState, error) {
+	state := SyncState{EventId: eventId}
+	_, err := store.get(bucketSync, eventId, &state)
+	return state, err
+}
+
+// SyncStates returns the sync states of all synced events, sorted by event ID.
+func (store *Store) SyncStates() ([]SyncState, error) {
+	var states []SyncState
+	err := store.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSync).ForEach(func(k, v []byte) error {
+			var state SyncState
+			if err := json.Unmarshal(v, &state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+	return states, err
+}
+
+// SyncEventContext fetches the current event history and the results of all runs newer than the last sync of the
+// event, and stores them together with the new sync state. Runs up to the last sync are neither fetched nor read.
+func (client *Client) SyncEventContext(ctx context.Context, event *Event) (SyncResult, error) {
+	if client.Store == nil {
+		return SyncResult{}, ErrNoStore
+	}
+	state, err := client.Store.SyncState(event.Id)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	// the event history is the only page that tells about new runs
+	if err := event.complete(ctx, 0); err != nil {
+		return SyncResult{}, err
+	}
+
+	newRuns := make([]*Run, 0)
+	for _, run := range event.Runs {
+		if run.Index > state.LastIndex {
+			newRuns = append(newRuns, run)
+		}
+	}
+	if err := client.completeRuns(ctx, newRuns); err != nil {
+		return SyncResult{}, err
+	}
+
+	result := SyncResult{SyncState: state, NewRuns: make([]uint64, 0, len(newRuns))}
+	for _, run := range newRuns {
+		result.NewRuns = append(result.NewRuns, run.Index)
+		if run.Index > result.LastIndex {
+			result.LastIndex = run.Index
+		}
+	}
+	result.LastSync = time.Now()
+	client.logger().Debug("synced event", "event", event.Id, "new_runs", len(newRuns), "last_index", result.LastIndex)
+	if err := client.Store.put(bucketSync, event.Id, &result.SyncState); err != nil {
+		return SyncResult{}, err
+	}
+	return result, client.Store.Flush()
+}